@@ -0,0 +1,93 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultConcurrency int = 4
+)
+
+// Outcome of sending items for many metrics at once, keyed by metric ID.
+type BatchResult map[string]SendResult
+
+// Returns the errors of all metrics that failed, joined together, or nil if
+// every metric was sent.
+func (r BatchResult) Err() error {
+	metricIDs := make([]string, 0, len(r))
+	for metricID := range r {
+		metricIDs = append(metricIDs, metricID)
+	}
+	sort.Strings(metricIDs)
+
+	var errs []error
+	for _, metricID := range metricIDs {
+		if err := r[metricID].Err(); err != nil {
+			errs = append(errs, fmt.Errorf("metric %s: %w", metricID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Sends timeseries items for many metrics at once. Metrics are sent
+// concurrently by a bounded pool of workers (see WithConcurrency), and items
+// for each metric are chunked as in SendAllMetricItems. A failure for one
+// metric does not stop the others; the result reports the outcome for every
+// metric, and the returned error joins all failures.
+func (client *Client) SendBatch(ctx context.Context, items map[string][]MetricItem) (BatchResult, error) {
+	return sendBatch(ctx, client.concurrency, items, client.SendAllMetricItems)
+}
+
+// Sends chart data rows for many metrics at once, in the same way as
+// SendBatch. Existing rows are not deleted first.
+func (client *Client) SendChartDataBatch(ctx context.Context, items map[string][]ChartData) (BatchResult, error) {
+	return sendBatch(ctx, client.concurrency, items, client.SendAllChartData)
+}
+
+func sendBatch[T any](
+	ctx context.Context,
+	concurrency int,
+	items map[string][]T,
+	send func(context.Context, string, []T) (SendResult, error),
+) (BatchResult, error) {
+	metricIDs := make([]string, 0, len(items))
+	for metricID := range items {
+		metricIDs = append(metricIDs, metricID)
+	}
+	sort.Strings(metricIDs)
+
+	work := make(chan string)
+	go func() {
+		defer close(work)
+		for _, metricID := range metricIDs {
+			work <- metricID
+		}
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := make(BatchResult, len(items))
+
+	for i := 0; i < concurrency && i < len(metricIDs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for metricID := range work {
+				r, _ := send(ctx, metricID, items[metricID])
+
+				mu.Lock()
+				result[metricID] = r
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return result, result.Err()
+}
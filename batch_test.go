@@ -0,0 +1,71 @@
+package panobi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_SendBatch(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		var req MetricItems
+		json.NewDecoder(r.Body).Decode(&req)
+		time.Sleep(10 * time.Millisecond)
+		if req.MetricID == "m3" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	items := make(map[string][]MetricItem)
+	for i := 0; i < 10; i++ {
+		items[fmt.Sprintf("m%d", i)] = make([]MetricItem, 1500)
+	}
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithConcurrency(3))
+	result, err := client.SendBatch(context.Background(), items)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !strings.Contains(err.Error(), "metric m3") {
+		t.Errorf("expected error for metric m3 but got `%v`", err)
+	}
+	if len(result) != 10 {
+		t.Errorf("expected results for 10 metrics but got %d", len(result))
+	}
+	for metricID, r := range result {
+		wantSent := 1500
+		if metricID == "m3" {
+			wantSent = 0
+		}
+		if r.Sent() != wantSent {
+			t.Errorf("expected %d item(s) sent for %s but got %d", wantSent, metricID, r.Sent())
+		}
+	}
+	if maxInFlight > 3 {
+		t.Errorf("expected at most 3 requests in flight but got %d", maxInFlight)
+	}
+}
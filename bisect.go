@@ -0,0 +1,200 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// An item that the server rejected, found by bisecting a rejected batch.
+type RejectedItem struct {
+	Index   int    // index of the item in the batch given to the client
+	Message string // error message the server returned for the item
+	Err     error  // error the server returned for the item
+}
+
+// Returned when bisecting a rejected batch has found the items that caused
+// the rejection. All other items in the batch were stored.
+type RejectedItemsError struct {
+	Rejected []RejectedItem // rejected items, in the order they were given
+	Err      error          // the rejection of the whole batch
+}
+
+func (e *RejectedItemsError) Error() string {
+	return fmt.Sprintf("%d item(s) rejected, first at index %d: %s",
+		len(e.Rejected), e.Rejected[0].Index, e.Rejected[0].Message)
+}
+
+func (e *RejectedItemsError) Unwrap() error {
+	return e.Err
+}
+
+// bounds the requests made to bisect one batch; halves still rejected once
+// it is spent are reported as rejected as a whole
+const maxBisectRequests int = 128
+
+// not an error the caller sees, only a signal that a rejection is not about
+// any one item
+var errRejectedAsWhole = errors.New("rejected as a whole")
+
+// Sends a batch of items, whose first item is at the given offset of the
+// batch given to the client. If bisecting is enabled and the server rejects
+// the batch, it is split to find the items that caused the rejection.
+// Returns the request for the whole batch, if one was built.
+func sendItems[T any](
+	ctx context.Context,
+	client *Client,
+	items []T,
+	offset int,
+	build func([]T) (*request, error),
+) (*request, error) {
+	req, err := build(items)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.bisect || len(items) < 2 {
+		return req, client.do(ctx, req)
+	}
+
+	err = client.send(ctx, req)
+	if !isRejected(err) {
+		if isPermanent(err) {
+			client.deadLetter(req, err)
+		}
+		return req, err
+	}
+
+	var rejected []RejectedItem
+	var spooled []error
+	budget := maxBisectRequests
+	switch berr := bisect(ctx, client, items, offset, build, err, &budget, &rejected, &spooled); {
+	case berr == errRejectedAsWhole:
+		client.deadLetter(req, err)
+		return req, err
+	case berr != nil:
+		return req, berr
+	}
+
+	// every half was stored, rejected or spooled, so nothing is lost
+	if len(spooled) > 0 {
+		if len(rejected) > 0 {
+			spooled = append(spooled, &RejectedItemsError{Rejected: rejected, Err: err})
+		}
+		return req, errors.Join(spooled...)
+	}
+
+	// the halves may all have been accepted, if the batch was only rejected
+	// as a whole
+	if len(rejected) == 0 {
+		return req, nil
+	}
+
+	return req, &RejectedItemsError{Rejected: rejected, Err: err}
+}
+
+// Sends each half of the items, which were rejected with the given error,
+// and splits further any half that is rejected, until the rejected items
+// are found. If both halves are rejected the same way as the items, the
+// rejection is not about any one item, and errRejectedAsWhole is returned.
+// A half that fails but is spooled is delivered later, so its errors are
+// collected and the other halves are still sent. Stops at the first other
+// failure, whose error does not match ErrSpooled even if earlier halves
+// were spooled, so that the caller sends the items again.
+func bisect[T any](
+	ctx context.Context,
+	client *Client,
+	items []T,
+	offset int,
+	build func([]T) (*request, error),
+	whole error,
+	budget *int,
+	rejected *[]RejectedItem,
+	spooled *[]error,
+) error {
+	type half struct {
+		items  []T
+		offset int
+		req    *request
+		err    error
+	}
+
+	mid := len(items) / 2
+	var failed []half
+	for _, h := range []half{
+		{items: items[:mid], offset: offset},
+		{items: items[mid:], offset: offset + mid},
+	} {
+		req, err := build(h.items)
+		if err != nil {
+			return err
+		}
+
+		*budget--
+		err = client.send(ctx, req)
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, ErrSpooled):
+			*spooled = append(*spooled, fmt.Errorf("bisecting items %d to %d: %w", h.offset, h.offset+len(h.items), err))
+			continue
+		case !isRejected(err):
+			return fmt.Errorf("bisecting items %d to %d: %w", h.offset, h.offset+len(h.items), err)
+		}
+
+		h.req, h.err = req, err
+		failed = append(failed, h)
+	}
+
+	if len(failed) == 2 && sameRejection(failed[0].err, whole) && sameRejection(failed[1].err, whole) {
+		return errRejectedAsWhole
+	}
+
+	for _, h := range failed {
+		if len(h.items) > 1 && *budget >= 2 {
+			err := bisect(ctx, client, h.items, h.offset, build, h.err, budget, rejected, spooled)
+			if err != errRejectedAsWhole {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		client.deadLetter(h.req, h.err)
+		for i := range h.items {
+			*rejected = append(*rejected, RejectedItem{
+				Index:   h.offset + i,
+				Message: errorMessage(h.err),
+				Err:     h.err,
+			})
+		}
+	}
+
+	return nil
+}
+
+// Reports whether two rejections have the same status and message.
+func sameRejection(a, b error) bool {
+	var errA, errB *APIError
+	return errors.As(a, &errA) && errors.As(b, &errB) &&
+		errA.StatusCode == errB.StatusCode && errA.Message == errB.Message
+}
+
+// Reports whether the server rejected the contents of a request, as opposed
+// to the request as a whole, for example because of its signature.
+func isRejected(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity)
+}
+
+func errorMessage(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Message
+	}
+
+	return err.Error()
+}
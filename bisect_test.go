@@ -0,0 +1,236 @@
+package panobi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/civil"
+)
+
+func Test_Bisect(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName      string
+		opts          []Option
+		unknownMetric bool
+		wantRejected  []int
+		wantStored    int
+		wantDead      int
+		wantRequests  int
+	}{
+		{
+			testName:     "disabled",
+			wantRejected: nil,
+			wantStored:   0,
+			wantDead:     1,
+			wantRequests: 1,
+		},
+		{
+			testName:     "enabled",
+			opts:         []Option{WithBisect()},
+			wantRejected: []int{3, 7},
+			wantStored:   8,
+			wantDead:     2,
+			wantRequests: 13,
+		},
+		{
+			// bisecting stops once both halves are rejected the same way
+			// as the batch
+			testName:      "rejected as a whole",
+			opts:          []Option{WithBisect()},
+			unknownMetric: true,
+			wantRejected:  nil,
+			wantStored:    0,
+			wantDead:      1,
+			wantRequests:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var mu sync.Mutex
+			stored, requests := 0, 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				mu.Unlock()
+
+				if tt.unknownMetric {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"error":{"message":"unknown metric"}}`))
+					return
+				}
+
+				// the message names the first bad item, as the API does
+				var req MetricItems
+				json.NewDecoder(r.Body).Decode(&req)
+				for _, item := range req.Items {
+					if item.Value < 0 {
+						w.WriteHeader(http.StatusBadRequest)
+						fmt.Fprintf(w, `{"error":{"message":"%s: invalid value"}}`, item.Date)
+						return
+					}
+				}
+				mu.Lock()
+				stored += len(req.Items)
+				mu.Unlock()
+			}))
+			defer srv.Close()
+
+			items := make([]MetricItem, 10)
+			for i := range items {
+				items[i] = MetricItem{Date: civil.Date{Year: 2023, Month: 1, Day: i + 1}, Value: 1}
+			}
+			items[3].Value = -1
+			items[7].Value = -1
+
+			var buf bytes.Buffer
+			opts := append([]Option{WithBaseURL(srv.URL), WithDeadLetters(NewDeadLetterWriter(&buf))}, tt.opts...)
+			err := CreateClient(ki, opts...).SendMetricItems("metric", items)
+
+			var rejectedErr *RejectedItemsError
+			if tt.wantRejected == nil {
+				if errors.As(err, &rejectedErr) || err == nil {
+					t.Errorf("expected a plain rejection but got `%v`", err)
+				}
+			} else {
+				if !errors.As(err, &rejectedErr) {
+					t.Fatalf("expected a RejectedItemsError but got `%v`", err)
+				}
+				if len(rejectedErr.Rejected) != len(tt.wantRejected) {
+					t.Fatalf("expected rejected items %v but got %+v", tt.wantRejected, rejectedErr.Rejected)
+				}
+				for i, r := range rejectedErr.Rejected {
+					if r.Index != tt.wantRejected[i] || !strings.HasSuffix(r.Message, "invalid value") {
+						t.Errorf("expected item %d rejected with `invalid value` but got %+v", tt.wantRejected[i], r)
+					}
+				}
+			}
+
+			if requests != tt.wantRequests {
+				t.Errorf("expected %d request(s) but got %d", tt.wantRequests, requests)
+			}
+			if stored != tt.wantStored {
+				t.Errorf("expected %d item(s) stored but got %d", tt.wantStored, stored)
+			}
+
+			dls, _ := ReadDeadLetters(&buf)
+			if len(dls) != tt.wantDead {
+				t.Errorf("expected %d dead letter(s) but got %d", tt.wantDead, len(dls))
+			}
+		})
+	}
+}
+
+func Test_Bisect_Budget(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	// every item is bad, with a message of its own
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req MetricItems
+		json.NewDecoder(r.Body).Decode(&req)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":{"message":"%s: invalid value"}}`, req.Items[0].Date)
+	}))
+	defer srv.Close()
+
+	items := make([]MetricItem, MaxItems)
+	for i := range items {
+		items[i] = MetricItem{Date: civil.Date{Year: 2023, Month: 1, Day: 1}.AddDays(i), Value: -1}
+	}
+
+	err := CreateClient(ki, WithBaseURL(srv.URL), WithBisect()).SendMetricItems("metric", items)
+
+	var rejectedErr *RejectedItemsError
+	if !errors.As(err, &rejectedErr) {
+		t.Fatalf("expected a RejectedItemsError but got `%v`", err)
+	}
+	if got := len(rejectedErr.Rejected); got != len(items) {
+		t.Errorf("expected all %d items rejected but got %d", len(items), got)
+	}
+	if requests > 1+maxBisectRequests {
+		t.Errorf("expected at most %d requests but got %d", 1+maxBisectRequests, requests)
+	}
+}
+
+func Test_Bisect_Spool(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	// the first half is unavailable once, and item 3 is bad
+	var mu sync.Mutex
+	var stored []int
+	unavailable := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var req MetricItems
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Items) == 2 && req.Items[0].Date.Day == 1 && unavailable {
+			unavailable = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for _, item := range req.Items {
+			if item.Value < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error":{"message":"%s: invalid value"}}`, item.Date)
+				return
+			}
+		}
+		for _, item := range req.Items {
+			stored = append(stored, item.Date.Day-1)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := OpenSpool(t.TempDir(), WithDrainInterval(0))
+	if err != nil {
+		t.Fatalf("unexpected error opening spool: %v", err)
+	}
+	defer s.Close()
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithBisect(), WithSpool(s), WithRetryPolicy(RetryPolicy{Attempts: 1}))
+	defer client.Close()
+
+	items := make([]MetricItem, 4)
+	for i := range items {
+		items[i] = MetricItem{Date: civil.Date{Year: 2023, Month: 1, Day: i + 1}, Value: 1}
+	}
+	items[3].Value = -1
+
+	// the spooled half does not stop its sibling from being bisected
+	err = client.SendMetricItems("metric", items)
+
+	var rejectedErr *RejectedItemsError
+	if !errors.Is(err, ErrSpooled) || !errors.As(err, &rejectedErr) {
+		t.Fatalf("expected a spooled error listing rejected items but got `%v`", err)
+	}
+	if len(rejectedErr.Rejected) != 1 || rejectedErr.Rejected[0].Index != 3 {
+		t.Errorf("expected item 3 rejected but got %+v", rejectedErr.Rejected)
+	}
+	if s.Len() != 1 {
+		t.Errorf("expected 1 spooled request but got %d", s.Len())
+	}
+
+	if err := client.DrainSpool(context.Background()); err != nil {
+		t.Fatalf("unexpected error draining: %v", err)
+	}
+	sort.Ints(stored)
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("expected items %v stored but got %v", want, stored)
+	}
+}
@@ -0,0 +1,312 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize int = 10_000
+	// flush errors kept for Close; any more are only counted
+	maxBufferedErrors int = 100
+)
+
+var (
+	// returned by Enqueue when the queue is full and the overflow policy is
+	// OverflowError
+	ErrQueueFull = errors.New("queue full")
+	// returned by Enqueue after Close has been called
+	ErrClosed = errors.New("client closed")
+)
+
+// Decides what Enqueue does when the queue of a BufferedClient is full.
+type OverflowPolicy int
+
+const (
+	OverflowBlock OverflowPolicy = iota // wait until there is room
+	OverflowDrop                        // discard the item
+	OverflowError                       // return ErrQueueFull
+)
+
+// Configures a BufferedClient. Pass any number of options to
+// CreateBufferedClient.
+type BufferedOption func(*bufferedOptions)
+
+type bufferedOptions struct {
+	period    time.Duration
+	queueSize int
+	overflow  OverflowPolicy
+	onError   func(metricID string, items []MetricItem, err error)
+}
+
+// Flushes buffered items at the given interval instead of every 10 seconds.
+func WithFlushPeriod(d time.Duration) BufferedOption {
+	return func(o *bufferedOptions) {
+		o.period = d
+	}
+}
+
+// Bounds the number of items held in memory. Up to n items wait to be
+// buffered, and up to n more are buffered across all metrics; once the
+// buffer is full, the metric with the most items is sent early to make
+// room. Defaults to 10,000.
+func WithQueueSize(n int) BufferedOption {
+	return func(o *bufferedOptions) {
+		o.queueSize = n
+	}
+}
+
+// Decides what Enqueue does when the queue is full. Defaults to
+// OverflowBlock.
+func WithOverflowPolicy(p OverflowPolicy) BufferedOption {
+	return func(o *bufferedOptions) {
+		o.overflow = p
+	}
+}
+
+// Calls the given function whenever a flush fails, instead of collecting
+// the error for Close. It is called from the flushing goroutine.
+func WithErrorHandler(f func(metricID string, items []MetricItem, err error)) BufferedOption {
+	return func(o *bufferedOptions) {
+		o.onError = f
+	}
+}
+
+type queuedItem struct {
+	metricID string
+	item     MetricItem
+}
+
+// Client that accumulates timeseries items in memory and sends them in the
+// background. Items are batched per metric, and sent either every flush
+// period, as soon as a metric has MaxItems waiting, or when the buffer is
+// full. Items for the same metric are sent in the order they were enqueued.
+type BufferedClient struct {
+	client *Client
+	o      bufferedOptions
+	queue  chan queuedItem
+
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{} // closed before mu is taken, to release blocked Enqueues
+	once    sync.Once
+	stop    chan struct{}
+	done    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	buffered int // items waiting across all metrics, only used by run
+
+	dropped  uint64
+	errMu    sync.Mutex
+	errs     []error
+	moreErrs int // errors beyond maxBufferedErrors
+}
+
+// Creates a buffered client that sends items using the given client. Call
+// Close to flush any remaining items and stop the background goroutine.
+func CreateBufferedClient(client *Client, opts ...BufferedOption) *BufferedClient {
+	o := bufferedOptions{
+		period:    bufferedSendPeriod,
+		queueSize: defaultQueueSize,
+		overflow:  OverflowBlock,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.period <= 0 {
+		o.period = bufferedSendPeriod
+	}
+	if o.queueSize < 1 {
+		o.queueSize = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &BufferedClient{
+		client:  client,
+		o:       o,
+		queue:   make(chan queuedItem, o.queueSize),
+		closing: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	go b.run()
+
+	return b
+}
+
+// Queues a single metric item to be sent later. Returns immediately unless
+// the queue is full and the overflow policy is OverflowBlock, in which case
+// it waits for room, or returns ErrClosed once Close is called.
+func (b *BufferedClient) Enqueue(metricID string, item MetricItem) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	qi := queuedItem{metricID: metricID, item: item}
+	if b.o.overflow == OverflowBlock {
+		select {
+		case b.queue <- qi:
+			return nil
+		case <-b.closing:
+			return ErrClosed
+		}
+	}
+
+	select {
+	case b.queue <- qi:
+		return nil
+	default:
+		if b.o.overflow == OverflowDrop {
+			atomic.AddUint64(&b.dropped, 1)
+			return nil
+		}
+		return ErrQueueFull
+	}
+}
+
+// Returns the number of items discarded because the queue was full.
+func (b *BufferedClient) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// Stops accepting items, flushes everything still buffered and waits for
+// the flush to finish. If the context is done first, in-flight requests are
+// cancelled. Returns the errors of failed flushes that were not passed to
+// an error handler; after the first 100, they are only counted.
+func (b *BufferedClient) Close(ctx context.Context) error {
+	b.once.Do(func() { close(b.closing) })
+
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.stop)
+	}
+	b.mu.Unlock()
+
+	var ctxErr error
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		ctxErr = canceled(ctx.Err())
+		b.cancel()
+		<-b.done
+	}
+	b.cancel()
+
+	b.errMu.Lock()
+	defer b.errMu.Unlock()
+
+	errs := append([]error{ctxErr}, b.errs...)
+	if b.moreErrs > 0 {
+		errs = append(errs, fmt.Errorf("%d more flush error(s)", b.moreErrs))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *BufferedClient) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.o.period)
+	defer ticker.Stop()
+
+	pending := make(map[string][]MetricItem)
+
+	for {
+		select {
+		case qi := <-b.queue:
+			b.add(pending, qi)
+		case <-ticker.C:
+			b.flushAll(pending)
+		case <-b.stop:
+			for {
+				select {
+				case qi := <-b.queue:
+					b.add(pending, qi)
+				default:
+					b.flushAll(pending)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *BufferedClient) add(pending map[string][]MetricItem, qi queuedItem) {
+	pending[qi.metricID] = append(pending[qi.metricID], qi.item)
+	b.buffered++
+
+	switch {
+	case len(pending[qi.metricID]) >= MaxItems:
+		b.flush(pending, qi.metricID)
+	case b.buffered >= b.o.queueSize:
+		b.flush(pending, largest(pending))
+	}
+}
+
+// Returns the metric with the most items waiting, which frees the most
+// room when it is sent. Ties go to the first metric ID in sort order.
+func largest(pending map[string][]MetricItem) string {
+	var max string
+	for metricID, items := range pending {
+		if n := len(pending[max]); len(items) > n || len(items) == n && metricID < max {
+			max = metricID
+		}
+	}
+
+	return max
+}
+
+func (b *BufferedClient) flushAll(pending map[string][]MetricItem) {
+	metricIDs := make([]string, 0, len(pending))
+	for metricID := range pending {
+		metricIDs = append(metricIDs, metricID)
+	}
+	sort.Strings(metricIDs)
+
+	for _, metricID := range metricIDs {
+		b.flush(pending, metricID)
+	}
+}
+
+func (b *BufferedClient) flush(pending map[string][]MetricItem, metricID string) {
+	items := pending[metricID]
+	delete(pending, metricID)
+	b.buffered -= len(items)
+
+	if len(items) == 0 {
+		return
+	}
+
+	err := b.client.SendMetricItemsContext(b.ctx, metricID, items)
+	if err == nil {
+		return
+	}
+
+	if b.o.onError != nil {
+		b.o.onError(metricID, items, err)
+		return
+	}
+
+	b.errMu.Lock()
+	defer b.errMu.Unlock()
+
+	if len(b.errs) >= maxBufferedErrors {
+		b.moreErrs++
+		return
+	}
+	b.errs = append(b.errs, fmt.Errorf("metric %s: %w", metricID, err))
+}
@@ -0,0 +1,257 @@
+package panobi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+func Test_BufferedClient_Flush(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var mu sync.Mutex
+	var batches []int
+	var values []float64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req MetricItems
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("unexpected error decoding request: %v", err)
+		}
+		mu.Lock()
+		batches = append(batches, len(req.Items))
+		for _, item := range req.Items {
+			values = append(values, item.Value)
+		}
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	b := CreateBufferedClient(
+		CreateClient(ki, WithBaseURL(srv.URL)),
+		WithFlushPeriod(time.Hour))
+
+	date := civil.Date{Year: 2023, Month: 1, Day: 1}
+	for i := 0; i < 2500; i++ {
+		if err := b.Enqueue("metric", MetricItem{Date: date.AddDays(i), Value: float64(i)}); err != nil {
+			t.Fatalf("unexpected error enqueueing: %v", err)
+		}
+	}
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Errorf("unexpected error closing: %v", err)
+	}
+	if err := b.Enqueue("metric", MetricItem{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected err to be `%v` but got `%v`", ErrClosed, err)
+	}
+
+	if len(batches) != 3 || batches[0] != 1000 || batches[1] != 1000 || batches[2] != 500 {
+		t.Errorf("expected batches of 1000, 1000 and 500 but got %v", batches)
+	}
+	for i, v := range values {
+		if v != float64(i) {
+			t.Fatalf("expected items in order but item %d has value %v", i, v)
+		}
+	}
+}
+
+func Test_BufferedClient_ManyMetrics(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req MetricItems
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		received[req.MetricID] += len(req.Items)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	const queueSize, metrics, perMetric = 10, 100, 10
+	b := CreateBufferedClient(
+		CreateClient(ki, WithBaseURL(srv.URL)),
+		WithFlushPeriod(time.Hour),
+		WithQueueSize(queueSize))
+
+	date := civil.Date{Year: 2023, Month: 1, Day: 1}
+	for i := 0; i < perMetric; i++ {
+		for m := 0; m < metrics; m++ {
+			if err := b.Enqueue(fmt.Sprintf("metric-%d", m), MetricItem{Date: date.AddDays(i), Value: float64(i)}); err != nil {
+				t.Fatalf("unexpected error enqueueing: %v", err)
+			}
+		}
+	}
+
+	// no flush period has passed, so only the bound on buffered items can
+	// have sent the rest: at most a full queue, a full buffer and a flush
+	// in flight are still held
+	mu.Lock()
+	sent := 0
+	for _, n := range received {
+		sent += n
+	}
+	mu.Unlock()
+	if held := metrics*perMetric - sent; held > 3*queueSize {
+		t.Errorf("expected at most %d items held in memory but got %d", 3*queueSize, held)
+	}
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Errorf("unexpected error closing: %v", err)
+	}
+	for m := 0; m < metrics; m++ {
+		if n := received[fmt.Sprintf("metric-%d", m)]; n != perMetric {
+			t.Errorf("expected %d items for metric %d but got %d", perMetric, m, n)
+		}
+	}
+}
+
+func Test_BufferedClient_Overflow(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName    string
+		policy      OverflowPolicy
+		wantErr     error
+		wantDropped uint64
+	}{
+		{
+			testName:    "drop",
+			policy:      OverflowDrop,
+			wantErr:     nil,
+			wantDropped: 1,
+		},
+		{
+			testName:    "error",
+			policy:      OverflowError,
+			wantErr:     ErrQueueFull,
+			wantDropped: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			received := make(chan struct{}, 1)
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case received <- struct{}{}:
+				default:
+				}
+				<-release
+			}))
+			defer srv.Close()
+
+			b := CreateBufferedClient(
+				CreateClient(ki, WithBaseURL(srv.URL)),
+				WithFlushPeriod(time.Millisecond),
+				WithQueueSize(2),
+				WithOverflowPolicy(tt.policy))
+
+			// the first flush blocks the background goroutine, so the queue
+			// fills up behind it
+			if err := b.Enqueue("metric", MetricItem{}); err != nil {
+				t.Fatalf("unexpected error enqueueing: %v", err)
+			}
+			<-received
+
+			for i := 0; i < 2; i++ {
+				if err := b.Enqueue("metric", MetricItem{}); err != nil {
+					t.Fatalf("unexpected error enqueueing: %v", err)
+				}
+			}
+			if err := b.Enqueue("metric", MetricItem{}); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected err to be `%v` but got `%v`", tt.wantErr, err)
+			}
+			if got := b.Dropped(); got != tt.wantDropped {
+				t.Errorf("expected %d dropped item(s) but got %d", tt.wantDropped, got)
+			}
+
+			close(release)
+			if err := b.Close(context.Background()); err != nil {
+				t.Errorf("unexpected error closing: %v", err)
+			}
+		})
+	}
+}
+
+func Test_BufferedClient_CloseBlocked(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	// every flush is stuck retrying
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	b := CreateBufferedClient(
+		CreateClient(ki, WithBaseURL(srv.URL), WithRetryPolicy(RetryPolicy{Attempts: 1000, BackoffInitial: time.Second})),
+		WithFlushPeriod(time.Millisecond),
+		WithQueueSize(1))
+
+	// the queue fills up behind the stuck flush, so an Enqueue blocks
+	blocked := make(chan error)
+	go func() {
+		for {
+			if err := b.Enqueue("metric", MetricItem{}); err != nil {
+				blocked <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error)
+	go func() { done <- b.Close(ctx) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected err to match `%v` but got `%v`", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to give up once its context was done")
+	}
+	if err := <-blocked; !errors.Is(err, ErrClosed) {
+		t.Errorf("expected the blocked Enqueue to return `%v` but got `%v`", ErrClosed, err)
+	}
+}
+
+func Test_BufferedClient_Errors(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	b := CreateBufferedClient(
+		CreateClient(ki, WithBaseURL(srv.URL)),
+		WithFlushPeriod(time.Hour))
+
+	for i := 0; i < maxBufferedErrors+5; i++ {
+		if err := b.Enqueue(fmt.Sprintf("metric%d", i), MetricItem{}); err != nil {
+			t.Fatalf("unexpected error enqueueing: %v", err)
+		}
+	}
+
+	err := b.Close(context.Background())
+	if got := len(err.(interface{ Unwrap() []error }).Unwrap()); got != maxBufferedErrors+1 {
+		t.Errorf("expected %d errors but got %d", maxBufferedErrors+1, got)
+	}
+	if !strings.Contains(err.Error(), "5 more flush error(s)") {
+		t.Errorf("expected the other errors to be counted but got `%v`", err)
+	}
+}
@@ -0,0 +1,174 @@
+package panobi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Describes one request made while sending a large batch of items.
+type ChunkResult struct {
+	Start     int    // index of the first item in the chunk
+	End       int    // index one past the last item in the chunk
+	RequestID string // X-Request-ID sent for the chunk
+	Err       error  // nil if the chunk was stored; a *RejectedItemsError if only some of it was
+}
+
+// Outcome of sending a large batch of items in chunks. Chunks are sent in
+// order and sending stops at the first chunk that fails, so the items that
+// still need sending are always items[Sent():]. A chunk whose rejected items
+// were found by bisecting does not stop sending, as its other items were
+// stored; its rejected items are listed by Rejected and must not be resent
+// as they are. A chunk that was spooled, in whole or in part, stops sending
+// too, but as its error matches ErrSpooled, the spool delivers it later and
+// only the chunks after it need sending.
+type SendResult struct {
+	Chunks []ChunkResult // chunks in the order they were attempted
+	Total  int           // number of items given
+}
+
+// Returns the number of items that were sent, including any that were
+// rejected by the server and listed by Rejected.
+func (r SendResult) Sent() int {
+	n := 0
+	for _, c := range r.Chunks {
+		if c.Err != nil && !isBisected(c.Err) {
+			break
+		}
+		n = c.End
+	}
+
+	return n
+}
+
+// Returns the items that the server rejected, found by bisecting.
+func (r SendResult) Rejected() []RejectedItem {
+	var rejected []RejectedItem
+	for _, c := range r.Chunks {
+		var rejectedErr *RejectedItemsError
+		if errors.As(c.Err, &rejectedErr) {
+			rejected = append(rejected, rejectedErr.Rejected...)
+		}
+	}
+
+	return rejected
+}
+
+// Returns the errors of the chunks that failed, if any.
+func (r SendResult) Err() error {
+	var errs []error
+	for _, c := range r.Chunks {
+		if c.Err != nil {
+			errs = append(errs, fmt.Errorf("items %d to %d: %w", c.Start, c.End, c.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isBisected(err error) bool {
+	var rejectedErr *RejectedItemsError
+	return errors.As(err, &rejectedErr) && !errors.Is(err, ErrSpooled)
+}
+
+// Sends any number of metric items to your Panobi workspace, split into
+// chunks that fit within both MaxItems and the payload size limit.
+func (client *Client) SendAllMetricItems(ctx context.Context, metricID string, items []MetricItem) (SendResult, error) {
+	envelope, err := json.Marshal(&MetricItems{
+		MetricID: metricID,
+		Items:    []MetricItem{},
+	})
+	if err != nil {
+		return SendResult{Total: len(items)}, err
+	}
+
+	return sendAll(ctx, client, items, len(envelope), func(chunk []MetricItem) (*request, error) {
+		return metricItemsRequest(metricID, chunk)
+	})
+}
+
+// Sends any number of chart data rows to your Panobi workspace, split into
+// chunks that fit within both MaxItems and the payload size limit.
+func (client *Client) SendAllChartData(ctx context.Context, metricID string, items []ChartData) (SendResult, error) {
+	envelope, err := json.Marshal(&RequestChartData{
+		MetricID: metricID,
+		Items:    []ChartData{},
+	})
+	if err != nil {
+		return SendResult{Total: len(items)}, err
+	}
+
+	return sendAll(ctx, client, items, len(envelope), func(chunk []ChartData) (*request, error) {
+		return chartDataRequest(metricID, chunk)
+	})
+}
+
+func sendAll[T any](
+	ctx context.Context,
+	client *Client,
+	items []T,
+	overhead int,
+	build func([]T) (*request, error),
+) (SendResult, error) {
+	result := SendResult{Total: len(items)}
+
+	chunks, err := planChunks(items, overhead)
+	if err != nil {
+		return result, err
+	}
+
+	for _, c := range chunks {
+		req, err := sendItems(ctx, client, items[c.Start:c.End], c.Start, build)
+		if req != nil {
+			c.RequestID = req.id
+		}
+
+		c.Err = err
+		result.Chunks = append(result.Chunks, c)
+		if c.Err != nil && !isBisected(c.Err) {
+			return result, result.Err()
+		}
+	}
+
+	return result, result.Err()
+}
+
+// Splits items into consecutive chunks that each hold at most MaxItems items
+// and encode, together with the given envelope overhead, to at most
+// maxInputBytes. Fails if any single item is too large to send.
+func planChunks[T any](items []T, overhead int) ([]ChunkResult, error) {
+	var chunks []ChunkResult
+
+	start, size := 0, overhead
+	for i, item := range items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+
+		n := len(b)
+		if overhead+n > maxInputBytes {
+			return nil, fmt.Errorf("item %d: %w", i, newLimitError(ErrPayloadTooLarge, "input", maxInputBytes, "bytes"))
+		}
+
+		// items after the first are preceded by a comma
+		if i > start {
+			n++
+		}
+
+		if i-start == MaxItems || size+n > maxInputBytes {
+			chunks = append(chunks, ChunkResult{Start: start, End: i})
+			start, size = i, overhead
+			n = len(b)
+		}
+
+		size += n
+	}
+
+	if start < len(items) {
+		chunks = append(chunks, ChunkResult{Start: start, End: len(items)})
+	}
+
+	return chunks, nil
+}
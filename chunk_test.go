@@ -0,0 +1,151 @@
+package panobi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/civil"
+)
+
+func Test_planChunks(t *testing.T) {
+	wide := ChartData{"label": strings.Repeat("x", 10_000), "value": 1}
+
+	tests := []struct {
+		testName   string
+		items      []ChartData
+		wantChunks []ChunkResult
+		wantErr    error
+	}{
+		{
+			testName:   "empty",
+			items:      nil,
+			wantChunks: nil,
+		},
+		{
+			testName:   "by count",
+			items:      repeatChartData(ChartData{"value": 1}, 2500),
+			wantChunks: []ChunkResult{{Start: 0, End: 1000}, {Start: 1000, End: 2000}, {Start: 2000, End: 2500}},
+		},
+		{
+			testName:   "by size",
+			items:      repeatChartData(wide, 250),
+			wantChunks: []ChunkResult{{Start: 0, End: 104}, {Start: 104, End: 208}, {Start: 208, End: 250}},
+		},
+		{
+			testName: "item too large",
+			items:    []ChartData{{"label": strings.Repeat("x", maxInputBytes)}},
+			wantErr:  ErrPayloadTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			envelope, _ := json.Marshal(&RequestChartData{MetricID: "metric", Items: []ChartData{}})
+
+			got, err := planChunks(tt.items, len(envelope))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected err to match `%v` but got `%v`", tt.wantErr, err)
+			}
+			if len(got) != len(tt.wantChunks) {
+				t.Fatalf("expected chunks %v but got %v", tt.wantChunks, got)
+			}
+			for i, c := range got {
+				if c != tt.wantChunks[i] {
+					t.Errorf("expected chunk %d to be %v but got %v", i, tt.wantChunks[i], c)
+				}
+
+				b, _ := json.Marshal(&RequestChartData{MetricID: "metric", Items: tt.items[c.Start:c.End]})
+				if len(b) > maxInputBytes {
+					t.Errorf("expected chunk %d to fit but it encodes to %d bytes", i, len(b))
+				}
+			}
+		})
+	}
+}
+
+func Test_SendAllMetricItems(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	client := CreateClient(ki, WithBaseURL(srv.URL))
+	result, err := client.SendAllMetricItems(context.Background(), "metric", make([]MetricItem, 2500))
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a 400 error but got `%v`", err)
+	}
+	if got := result.Sent(); got != 1000 {
+		t.Errorf("expected 1000 items to be sent but got %d", got)
+	}
+	if got := len(result.Chunks); got != 2 {
+		t.Errorf("expected 2 chunks to be attempted but got %d", got)
+	}
+	if result.Total != 2500 {
+		t.Errorf("expected total of 2500 but got %d", result.Total)
+	}
+}
+
+func Test_SendAllMetricItems_Bisect(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var stored int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req MetricItems
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, item := range req.Items {
+			if item.Value < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error":{"message":"%s: invalid value"}}`, item.Date)
+				return
+			}
+		}
+		atomic.AddInt32(&stored, int32(len(req.Items)))
+	}))
+	defer srv.Close()
+
+	items := make([]MetricItem, 2500)
+	for i := range items {
+		items[i] = MetricItem{Date: civil.Date{Year: 2023, Month: 1, Day: 1}.AddDays(i)}
+	}
+	items[1500].Value = -1
+
+	// a chunk with rejected items does not stop the rest being sent
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithBisect())
+	result, err := client.SendAllMetricItems(context.Background(), "metric", items)
+
+	var rejectedErr *RejectedItemsError
+	if !errors.As(err, &rejectedErr) {
+		t.Errorf("expected a RejectedItemsError but got `%v`", err)
+	}
+	if got := result.Sent(); got != 2500 {
+		t.Errorf("expected 2500 items to be sent but got %d", got)
+	}
+	if got := result.Rejected(); len(got) != 1 || got[0].Index != 1500 {
+		t.Errorf("expected item 1500 to be rejected but got %+v", got)
+	}
+	if got := atomic.LoadInt32(&stored); got != 2499 {
+		t.Errorf("expected 2499 items to be stored but got %d", got)
+	}
+}
+
+func repeatChartData(item ChartData, n int) []ChartData {
+	items := make([]ChartData, n)
+	for i := range items {
+		items[i] = item
+	}
+	return items
+}
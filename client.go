@@ -1,8 +1,11 @@
 package panobi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -15,71 +18,285 @@ const (
 
 // Client for pushing metrics items to your Panobi workspace.
 type Client struct {
-	t *transport
+	t           *transport
+	concurrency int
+	spool       *Spool
+	deadLetters *DeadLetterWriter
+	bisect      bool
+	stats       *stats
+
+	drainMu sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
 }
 
-// Creates a new client with the given key information.
-func CreateClient(k KeyInfo) *Client {
+// Creates a new client with the given key information. Options may be
+// given to change where and how requests are sent.
+func CreateClient(k KeyInfo, opts ...Option) *Client {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// the client collects its own stats through the same hooks as the caller
+	st := newStats()
+	o.hooks = append([]Hooks{st}, o.hooks...)
+
+	ctx, cancel := context.WithCancel(context.Background())
 	c := &Client{
-		t: createTransport(k),
+		t:           createTransport(k, o),
+		concurrency: o.concurrency,
+		spool:       o.spool,
+		deadLetters: o.deadLetters,
+		bisect:      o.bisect,
+		stats:       st,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+
+	if c.spool != nil && c.spool.o.drainInterval > 0 {
+		go c.drain(c.spool.o.drainInterval)
+	} else {
+		close(c.done)
 	}
 
 	return c
 }
 
+// Stops any background work started by the client. A spool given with
+// WithSpool is left open.
 func (client *Client) Close() {
+	client.cancel()
+	<-client.done
 }
 
 // Sends a single metric item to your Panobi workspace.
 func (client *Client) SendMetricItem(metricID string, item MetricItem) error {
-	return client.SendMetricItems(metricID, []MetricItem{item})
+	return client.SendMetricItemContext(context.Background(), metricID, item)
+}
+
+// Like SendMetricItem, but the request is bound to the given context.
+func (client *Client) SendMetricItemContext(ctx context.Context, metricID string, item MetricItem) error {
+	return client.SendMetricItemsContext(ctx, metricID, []MetricItem{item})
 }
 
 // Sends multiple metric items to your Panobi workspace.
 func (client *Client) SendMetricItems(metricID string, items []MetricItem) error {
-	if len(items) > MaxItems {
-		return fmt.Errorf(errMaxNumberSize, "batch", MaxItems, "MetricItems")
-	}
+	return client.SendMetricItemsContext(context.Background(), metricID, items)
+}
+
+// Like SendMetricItems, but the request is bound to the given context.
+// Cancelling the context aborts the request, including any wait between
+// retries, and the returned error wraps ctx.Err().
+func (client *Client) SendMetricItemsContext(ctx context.Context, metricID string, items []MetricItem) error {
+	_, err := sendItems(ctx, client, items, 0, func(items []MetricItem) (*request, error) {
+		return metricItemsRequest(metricID, items)
+	})
+
+	return err
+}
+
+// Sends metric chart data rows to your Panobi workspace.
+func (client *Client) SendMetricChartData(metricID string, items []ChartData) error {
+	return client.SendMetricChartDataContext(context.Background(), metricID, items)
+}
+
+// Like SendMetricChartData, but the request is bound to the given context.
+func (client *Client) SendMetricChartDataContext(ctx context.Context, metricID string, items []ChartData) error {
+	_, err := sendItems(ctx, client, items, 0, func(items []ChartData) (*request, error) {
+		return chartDataRequest(metricID, items)
+	})
+
+	return err
+}
 
-	b, err := json.Marshal(&MetricItems{
+// Delete all stored rows for a metric (timeseries or non-timeseries)
+func (client *Client) DeleteMetricData(metricID string) error {
+	return client.DeleteMetricDataContext(context.Background(), metricID)
+}
+
+// Like DeleteMetricData, but the request is bound to the given context.
+func (client *Client) DeleteMetricDataContext(ctx context.Context, metricID string) error {
+	b, err := json.Marshal(&RequestMetricDataDelete{
 		MetricID: metricID,
-		Items:    items,
 	})
 	if err != nil {
 		return err
 	}
 
-	_, err = client.t.post(TimeseriesURI, b)
+	return client.do(ctx, &request{
+		uri:      DeleteURI,
+		metricID: metricID,
+		body:     b,
+	})
+}
+
+// Sends the request, writing it as a dead letter if it is permanently
+// rejected.
+func (client *Client) do(ctx context.Context, req *request) error {
+	err := client.send(ctx, req)
+	if isPermanent(err) {
+		client.deadLetter(req, err)
+	}
+
 	return err
 }
 
-// Sends metric chart data rows to your Panobi workspace.
-func (client *Client) SendMetricChartData(metricID string, items []ChartData) error {
+// Sends the request, through the spool if there is one.
+func (client *Client) send(ctx context.Context, req *request) error {
+	if client.spool == nil {
+		_, err := client.t.post(ctx, req)
+		return err
+	}
+
+	// the request ID is chosen up front so that it is spooled too, and a
+	// replay carries the same ID
+	ki, err := client.t.key(ctx)
+	if err != nil {
+		return err
+	}
+	req.id = client.t.requestID(req, ki)
+	e, err := client.spool.append(req)
+	if errors.Is(err, ErrSpoolFull) {
+		// a full spool must not stop requests that can be delivered now
+		client.t.log.Warn("panobi: spool full, sending without it", "request_id", req.id)
+		if _, err := client.t.post(ctx, req); err != nil {
+			return fmt.Errorf("%w: %w", ErrSpoolFull, err)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = client.t.post(ctx, req)
+	if err == nil || isContentRejection(err) {
+		// if the acknowledgement cannot be written, the request is sent
+		// again later with the same ID
+		client.ack(e)
+		return err
+	}
+
+	client.spool.release(e)
+
+	return fmt.Errorf("%w: %w", ErrSpooled, err)
+}
+
+// Sends requests waiting in the spool, oldest first. Requests whose contents
+// are permanently rejected are removed from the spool, written as dead
+// letters if WithDeadLetters was given, and their errors are returned
+// together. Draining stops at the first request that fails but may succeed
+// later, unless that request has failed several drains in a row: then it
+// may be at fault rather than the server, so it is moved to the back of the
+// spool to let the others through.
+func (client *Client) DrainSpool(ctx context.Context) error {
+	if client.spool == nil {
+		return nil
+	}
+
+	client.drainMu.Lock()
+	defer client.drainMu.Unlock()
+
+	var errs []error
+	entries := client.spool.claim()
+	for i, e := range entries {
+		_, err := client.t.post(ctx, e.req)
+		if err == nil || isContentRejection(err) {
+			if err != nil {
+				client.deadLetter(e.req, err)
+				errs = append(errs, err)
+			}
+			client.ack(e)
+			continue
+		}
+
+		if e.failures++; e.failures >= spoolMaxFailures && ctx.Err() == nil {
+			e.failures = 0
+			if qerr := client.spool.requeue(e); qerr != nil {
+				client.t.log.Error("panobi: cannot requeue spooled request",
+					"request_id", e.req.id,
+					"error", qerr)
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, rest := range entries[i:] {
+			client.spool.release(rest)
+		}
+
+		return errors.Join(append(errs, err)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (client *Client) ack(e *spoolEntry) {
+	if err := client.spool.ack(e); err != nil {
+		client.t.log.Error("panobi: cannot acknowledge spooled request",
+			"request_id", e.req.id,
+			"error", err)
+	}
+}
+
+func (client *Client) drain(interval time.Duration) {
+	defer close(client.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := client.DrainSpool(client.ctx); err != nil && client.ctx.Err() == nil {
+			client.t.log.Warn("panobi: cannot drain spool", "error", err)
+		}
+
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func metricItemsRequest(metricID string, items []MetricItem) (*request, error) {
 	if len(items) > MaxItems {
-		return fmt.Errorf(errMaxNumberSize, "batch", MaxItems, "ChartData")
+		return nil, newLimitError(ErrBatchTooLarge, "batch", MaxItems, "MetricItems")
 	}
 
-	b, err := json.Marshal(&RequestChartData{
+	b, err := marshalMetricItems(&MetricItems{
 		MetricID: metricID,
 		Items:    items,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = client.t.post(ChartDataURI, b)
-	return err
+	return &request{
+		uri:      TimeseriesURI,
+		metricID: metricID,
+		items:    len(items),
+		body:     b,
+	}, nil
 }
 
-// Delete all stored rows for a metric (timeseries or non-timeseries)
-func (client *Client) DeleteMetricData(metricID string) error {
-	b, err := json.Marshal(&RequestMetricDataDelete{
+func chartDataRequest(metricID string, items []ChartData) (*request, error) {
+	if len(items) > MaxItems {
+		return nil, newLimitError(ErrBatchTooLarge, "batch", MaxItems, "ChartData")
+	}
+
+	b, err := marshal(&RequestChartData{
 		MetricID: metricID,
+		Items:    items,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = client.t.post(DeleteURI, b)
-	return err
+	return &request{
+		uri:      ChartDataURI,
+		metricID: metricID,
+		items:    len(items),
+		body:     b,
+	}, nil
 }
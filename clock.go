@@ -0,0 +1,72 @@
+package panobi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Date headers only have one-second resolution, so smaller differences
+// between the clocks are not corrected for
+const minClockSkew time.Duration = 2 * time.Second
+
+// Returns the current time as the server sees it, as best we can tell.
+// Signatures are timestamped with it.
+func (t *transport) serverNow() time.Time {
+	t.skewMu.Lock()
+	defer t.skewMu.Unlock()
+
+	return t.now().Add(t.skew)
+}
+
+// Updates the estimated clock skew from the Date header of a response to a
+// request sent and answered at the given local times.
+func (t *transport) observeDate(resp *http.Response, sent, received time.Time) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	// the header was set somewhere between sending and receiving, and is
+	// truncated to the second
+	mid := sent.Add(received.Sub(sent) / 2)
+	skew := date.Add(500 * time.Millisecond).Sub(mid)
+	if skew.Abs() < minClockSkew {
+		skew = 0
+	}
+
+	t.skewMu.Lock()
+	defer t.skewMu.Unlock()
+
+	if (skew - t.skew).Abs() < minClockSkew {
+		return
+	}
+
+	t.log.Warn("panobi: correcting for clock skew", "skew", skew.Round(time.Second))
+	t.skew = skew
+}
+
+// Returns how far the signature timestamp of a rejected request was from the
+// server's clock, and whether that is likely to be why it was rejected. The
+// timestamp is taken from the request the response is for, falling back to
+// the given time the attempt was signed at, as a round tripper need not set
+// resp.Request.
+func rejectedForSkew(resp *http.Response, signedAt time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return 0, false
+	}
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	if resp.Request != nil {
+		if ms, err := strconv.ParseInt(resp.Request.Header.Get("X-Panobi-Request-Timestamp"), 10, 64); err == nil {
+			signedAt = time.UnixMilli(ms)
+		}
+	}
+
+	skew := date.Sub(signedAt)
+
+	return skew, skew.Abs() > DefaultSignatureTolerance
+}
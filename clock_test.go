@@ -0,0 +1,114 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_post_ClockSkew(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	other, _ := ParseKey("1234567890123456789012-1234567890123456789012-456")
+
+	tests := []struct {
+		testName  string
+		serverKey KeyInfo
+		skew      time.Duration
+		noRequest bool // the round tripper does not set resp.Request
+		attempts  int
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			testName:  "corrected on retry",
+			serverKey: ki,
+			skew:      -time.Hour,
+			attempts:  2,
+			wantCalls: 2,
+		},
+		{
+			testName:  "reported without retries",
+			serverKey: ki,
+			skew:      time.Hour,
+			attempts:  1,
+			wantErr:   ErrClockSkew,
+			wantCalls: 1,
+		},
+		{
+			testName:  "reported without the response's request",
+			serverKey: ki,
+			skew:      time.Hour,
+			noRequest: true,
+			attempts:  1,
+			wantErr:   ErrClockSkew,
+			wantCalls: 1,
+		},
+		{
+			testName:  "small skew is tolerated",
+			serverKey: ki,
+			skew:      time.Minute,
+			attempts:  1,
+			wantCalls: 1,
+		},
+		{
+			testName:  "wrong key is not skew",
+			serverKey: other,
+			attempts:  2,
+			wantErr:   ErrUnauthorized,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			calls := 0
+			verify := VerifyRequests([]KeyInfo{tt.serverKey}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				verify.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			// signed with v1, as v0 cannot tell the later request from a
+			// replay if it is signed within the same millisecond
+			opts := []Option{WithSignatureVersion(SignatureV1), WithRetryPolicy(RetryPolicy{
+				Attempts:       tt.attempts,
+				BackoffInitial: time.Millisecond,
+			})}
+			if tt.noRequest {
+				opts = append(opts, WithRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					resp, err := http.DefaultTransport.RoundTrip(r)
+					if resp != nil {
+						resp.Request = nil
+					}
+					return resp, err
+				})))
+			}
+
+			tr := createTransport(ki, testOptions(srv.URL, opts...))
+			tr.now = func() time.Time { return time.Now().Add(tt.skew) }
+
+			_, err := tr.post(context.Background(), &request{uri: TimeseriesURI, body: []byte("{}")})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected err to match `%v` but got `%v`", tt.wantErr, err)
+			}
+			if errors.Is(err, ErrClockSkew) && (tt.wantErr != ErrClockSkew || !errors.Is(err, ErrUnauthorized) || isPermanent(err)) {
+				t.Errorf("expected only a temporary, unauthorized clock skew error but got `%v`", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("expected %d call(s) but got %d", tt.wantCalls, calls)
+			}
+
+			// whatever happened, later requests are timestamped to match the
+			// server
+			if tt.serverKey.Equals(ki) {
+				if _, err := tr.post(context.Background(), &request{uri: TimeseriesURI, body: []byte("{}")}); err != nil {
+					t.Errorf("unexpected error on a later request: %v", err)
+				}
+			}
+		})
+	}
+}
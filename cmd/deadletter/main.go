@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	panobi "github.com/panobi/metrics-sdk"
+)
+
+const usage = `Usage: %[1]s <command> [flags] <filename>
+
+Inspects and re-submits dead letters written by a client created with the
+WithDeadLetters option. Use - as the filename to read from standard input.
+
+Commands:
+  list     print a summary of the matching dead letters
+  filter   print the matching dead letters as JSON lines, ready to be
+           edited and passed back to replay
+  replay   send the matching dead letters to Panobi again
+
+Flags for all commands:
+  -metric <id>          only dead letters for this metric ID
+  -endpoint <name>      only dead letters for this endpoint (timeseries,
+                        chart-data or delete)
+  -status <code>        only dead letters with this HTTP status code
+  -v                    invert the selection
+
+Flags for replay:
+  -base-url <url>       send to this base URL instead of Panobi
+  -out <filename>       append dead letters that are rejected again here
+
+Example:
+  %[1]s filter -metric XRnrRBTedmWzy8RQ6pqh2d dead.jsonl > retry.jsonl
+  # fix up retry.jsonl by hand
+  %[1]s replay retry.jsonl
+`
+
+type selection struct {
+	metricID string
+	endpoint string
+	status   int
+	invert   bool
+}
+
+func (s selection) matches(dl panobi.DeadLetter) bool {
+	ok := (s.metricID == "" || dl.MetricID == s.metricID) &&
+		(s.endpoint == "" || path.Base(dl.Endpoint) == s.endpoint) &&
+		(s.status == 0 || dl.StatusCode == s.status)
+
+	return ok != s.invert
+}
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		os.Exit(2)
+	}
+
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprintf(os.Stderr, usage, os.Args[0]) }
+
+	var sel selection
+	fs.StringVar(&sel.metricID, "metric", "", "")
+	fs.StringVar(&sel.endpoint, "endpoint", "", "")
+	fs.IntVar(&sel.status, "status", 0, "")
+	fs.BoolVar(&sel.invert, "v", false, "")
+	baseURL := fs.String("base-url", "", "")
+	out := fs.String("out", "", "")
+
+	if err := fs.Parse(os.Args[2:]); err != nil || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	//
+	// Read the dead letters and keep the ones we're interested in.
+	//
+
+	dls, err := readDeadLetters(fs.Arg(0))
+	if err != nil {
+		log.Fatal("Error reading dead letters: ", err)
+	}
+
+	var selected []panobi.DeadLetter
+	for _, dl := range dls {
+		if sel.matches(dl) {
+			selected = append(selected, dl)
+		}
+	}
+
+	switch cmd {
+	case "list":
+		list(selected)
+	case "filter":
+		filter(selected)
+	case "replay":
+		if !replay(selected, *baseURL, *out) {
+			os.Exit(1)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func readDeadLetters(filename string) ([]panobi.DeadLetter, error) {
+	if filename == "-" {
+		return panobi.ReadDeadLetters(os.Stdin)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return panobi.ReadDeadLetters(file)
+}
+
+func list(dls []panobi.DeadLetter) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIMESTAMP\tENDPOINT\tMETRIC ID\tSTATUS\tREQUEST ID\tERROR")
+	for _, dl := range dls {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			dl.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+			path.Base(dl.Endpoint),
+			dl.MetricID,
+			dl.StatusCode,
+			dl.RequestID,
+			truncate(dl.Error, 60))
+	}
+	w.Flush()
+}
+
+func filter(dls []panobi.DeadLetter) {
+	w := panobi.NewDeadLetterWriter(os.Stdout)
+	for _, dl := range dls {
+		if err := w.Write(dl); err != nil {
+			log.Fatal("Error writing dead letter: ", err)
+		}
+	}
+}
+
+// Returns false if any of the dead letters failed again.
+func replay(dls []panobi.DeadLetter, baseURL, out string) bool {
+	//
+	// You can find your key in your Panobi workspace's integration settings.
+	// It is safer to load it from an environment variable than to paste it
+	// directly into this code; do not put secrets in GitHub.
+	//
+
+	k, err := panobi.ParseKey(os.Getenv("METRICS_SDK_SIGNING_KEY"))
+	if err != nil {
+		log.Fatal("Error parsing key:", err)
+	}
+
+	opts := []panobi.Option{}
+	if baseURL != "" {
+		opts = append(opts, panobi.WithBaseURL(baseURL))
+	}
+
+	var failed *panobi.DeadLetterWriter
+	if out != "" {
+		if failed, err = panobi.OpenDeadLetterFile(out); err != nil {
+			log.Fatal("Error opening output file: ", err)
+		}
+		defer failed.Close()
+		opts = append(opts, panobi.WithDeadLetters(failed))
+	}
+
+	client := panobi.CreateClient(k, opts...)
+	defer client.Close()
+
+	//
+	// Send each dead letter again, carrying on past failures.
+	//
+
+	var errs int
+	for _, dl := range dls {
+		if err := client.Resubmit(context.Background(), dl); err != nil {
+			errs++
+			log.Printf("Error re-submitting %s for metricID %s: %s", path.Base(dl.Endpoint), dl.MetricID, err.Error())
+			continue
+		}
+
+		log.Printf("Successfully re-submitted %s for metricID %s", path.Base(dl.Endpoint), dl.MetricID)
+	}
+
+	if errs > 0 {
+		log.Printf("%d of %d dead letter(s) failed", errs, len(dls))
+		return false
+	}
+
+	return true
+}
+
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if len(s) <= n {
+		return s
+	}
+
+	return s[:n-3] + "..."
+}
@@ -0,0 +1,149 @@
+package panobi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A request that was permanently rejected by the Panobi API, as written to
+// a dead-letter file.
+type DeadLetter struct {
+	MetricID   string          `json:"metricID"`
+	Endpoint   string          `json:"endpoint"`
+	Body       json.RawMessage `json:"body"`
+	Error      string          `json:"error"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	RequestID  string          `json:"requestID"`
+	Timestamp  time.Time       `json:"timestamp"`
+}
+
+// Appends dead letters to a file, one JSON object per line. It is safe for
+// concurrent use.
+type DeadLetterWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+// Opens the given file for appending dead letters, creating it if needed.
+func OpenDeadLetterFile(path string) (*DeadLetterWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterWriter{w: f, f: f}, nil
+}
+
+// Creates a dead-letter writer that appends to the given writer.
+func NewDeadLetterWriter(w io.Writer) *DeadLetterWriter {
+	return &DeadLetterWriter{w: w}
+}
+
+// Appends a single dead letter.
+func (w *DeadLetterWriter) Write(dl DeadLetter) error {
+	b, err := json.Marshal(&dl)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	if w.f != nil {
+		return w.f.Sync()
+	}
+
+	return nil
+}
+
+// Closes the underlying file, if the writer was opened with
+// OpenDeadLetterFile.
+func (w *DeadLetterWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+
+	return w.f.Close()
+}
+
+// Reads all dead letters from the given reader, in the format written by a
+// DeadLetterWriter.
+func ReadDeadLetters(r io.Reader) ([]DeadLetter, error) {
+	var dls []DeadLetter
+
+	dec := json.NewDecoder(r)
+	for {
+		var dl DeadLetter
+		if err := dec.Decode(&dl); err == io.EOF {
+			return dls, nil
+		} else if err != nil {
+			return dls, fmt.Errorf("dead letter %d: %w", len(dls)+1, err)
+		}
+
+		dls = append(dls, dl)
+	}
+}
+
+// Sends the body of a dead letter again, to the endpoint it was originally
+// sent to. It is sent as a new request, with a new request ID, so the body
+// may have been edited in the meantime.
+func (client *Client) Resubmit(ctx context.Context, dl DeadLetter) error {
+	var uri apiURI
+	switch apiURI(dl.Endpoint) {
+	case TimeseriesURI, ChartDataURI, DeleteURI:
+		uri = apiURI(dl.Endpoint)
+	default:
+		return fmt.Errorf("unknown endpoint %q", dl.Endpoint)
+	}
+
+	if len(dl.Body) > maxInputBytes {
+		return newLimitError(ErrPayloadTooLarge, "input", maxInputBytes, "bytes")
+	}
+
+	return client.do(ctx, &request{
+		uri:      uri,
+		metricID: dl.MetricID,
+		body:     dl.Body,
+	})
+}
+
+// Records the request as a dead letter, if the client has somewhere to
+// write them.
+func (client *Client) deadLetter(req *request, err error) {
+	if client.deadLetters == nil {
+		return
+	}
+
+	dl := DeadLetter{
+		MetricID:  req.metricID,
+		Endpoint:  string(req.uri),
+		Body:      req.body,
+		Error:     err.Error(),
+		RequestID: req.id,
+		Timestamp: time.Now().UTC(),
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		dl.StatusCode = apiErr.StatusCode
+	}
+
+	// a dead letter that cannot be written is not worth failing the
+	// request for; the caller still gets the original error
+	if err := client.deadLetters.Write(dl); err != nil {
+		client.t.log.Error("panobi: cannot write dead letter",
+			"request_id", req.id,
+			"error", err)
+	}
+}
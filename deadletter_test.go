@@ -0,0 +1,105 @@
+package panobi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_DeadLetters(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName string
+		status   int
+		wantDead int
+	}{
+		{
+			testName: "accepted",
+			status:   http.StatusOK,
+			wantDead: 0,
+		},
+		{
+			testName: "rejected",
+			status:   http.StatusBadRequest,
+			wantDead: 1,
+		},
+		{
+			testName: "unavailable",
+			status:   http.StatusServiceUnavailable,
+			wantDead: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"error":{"message":"bad date"}}`))
+			}))
+			defer srv.Close()
+
+			var buf bytes.Buffer
+			client := CreateClient(ki,
+				WithBaseURL(srv.URL),
+				WithDeadLetters(NewDeadLetterWriter(&buf)),
+				WithRetryPolicy(RetryPolicy{Attempts: 1}))
+			client.SendMetricItems("metric", []MetricItem{{Value: 1}})
+
+			dls, err := ReadDeadLetters(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error reading dead letters: %v", err)
+			}
+			if len(dls) != tt.wantDead {
+				t.Fatalf("expected %d dead letter(s) but got %d", tt.wantDead, len(dls))
+			}
+			if tt.wantDead == 0 {
+				return
+			}
+
+			dl := dls[0]
+			if dl.MetricID != "metric" ||
+				dl.Endpoint != string(TimeseriesURI) ||
+				dl.StatusCode != tt.status ||
+				dl.Error != "http error 400: bad date" ||
+				dl.RequestID == "" ||
+				dl.Timestamp.IsZero() ||
+				!bytes.Contains(dl.Body, []byte(`"metricID":"metric"`)) {
+				t.Errorf("unexpected dead letter %+v", dl)
+			}
+		})
+	}
+}
+
+func Test_Client_Resubmit(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		buf.ReadFrom(r.Body)
+		gotPath, gotBody = r.URL.Path, buf.String()
+	}))
+	defer srv.Close()
+
+	client := CreateClient(ki, WithBaseURL(srv.URL))
+
+	dl := DeadLetter{
+		MetricID: "metric",
+		Endpoint: string(ChartDataURI),
+		Body:     []byte(`{"metricID":"metric","items":[{"label":"Foo"}]}`),
+	}
+	if err := client.Resubmit(context.Background(), dl); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if gotPath != string(ChartDataURI)+"/"+ki.WorkspaceID+"/"+ki.ExternalID || gotBody != string(dl.Body) {
+		t.Errorf("unexpected request to `%s` with body `%s`", gotPath, gotBody)
+	}
+
+	dl.Endpoint = "/elsewhere"
+	if err := client.Resubmit(context.Background(), dl); !errorIs(`unknown endpoint "/elsewhere"`, err) {
+		t.Errorf("expected unknown endpoint error but got `%v`", err)
+	}
+}
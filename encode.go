@@ -0,0 +1,159 @@
+package panobi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// Buffers that request bodies are encoded into, before being copied out at
+// their final size. Buffers larger than the API accepts are not kept, so
+// that one oversized request does not pin its memory.
+var encodeBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// Encodes v as JSON, the same as json.Marshal, through a pooled buffer.
+func marshal(v any) ([]byte, error) {
+	return encode(func(buf *bytes.Buffer) error {
+		enc := json.NewEncoder(buf)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		// the encoder ends each value with a newline, which Marshal does
+		// not
+		buf.Truncate(buf.Len() - 1)
+		return nil
+	})
+}
+
+// Encodes a request body into a pooled buffer with the given function, and
+// returns a copy of it.
+func encode(f func(*bytes.Buffer) error) ([]byte, error) {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxInputBytes {
+			buf.Reset()
+			encodeBuffers.Put(buf)
+		}
+	}()
+
+	if err := f(buf); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// Encodes a batch of timeseries items, producing the same JSON as
+// json.Marshal without allocating for every item. Falls back to
+// json.Marshal for anything it does not handle.
+func marshalMetricItems(mi *MetricItems) ([]byte, error) {
+	b, err := encode(func(buf *bytes.Buffer) error {
+		return encodeMetricItems(buf, mi)
+	})
+	if err == errUnencodable {
+		return marshal(mi)
+	}
+
+	return b, err
+}
+
+// only a signal to fall back to json.Marshal, which reports the real error
+// if there is one
+var errUnencodable = errors.New("unencodable")
+
+func encodeMetricItems(buf *bytes.Buffer, mi *MetricItems) error {
+	buf.WriteString(`{"metricID":`)
+	if err := encodeString(buf, mi.MetricID); err != nil {
+		return err
+	}
+
+	buf.WriteString(`,"items":`)
+	if mi.Items == nil {
+		buf.WriteString("null}")
+		return nil
+	}
+
+	var scratch [32]byte
+	buf.WriteByte('[')
+	for i, item := range mi.Items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		d := item.Date
+		if d.Year < 0 || d.Year > 9999 || d.Month < 1 || d.Month > 12 || d.Day < 1 || d.Day > 31 {
+			return errUnencodable
+		}
+		b := append(scratch[:0], `{"date":"`...)
+		b = appendDigits(b, d.Year, 4)
+		b = append(b, '-')
+		b = appendDigits(b, int(d.Month), 2)
+		b = append(b, '-')
+		b = appendDigits(b, d.Day, 2)
+		b = append(b, `","value":`...)
+		buf.Write(b)
+
+		b, err := appendFloat(scratch[:0], item.Value)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('}')
+	}
+	buf.WriteString("]}")
+
+	return nil
+}
+
+// Appends n zero-padded to the given width.
+func appendDigits(b []byte, n, width int) []byte {
+	for w := 10; width > 1 && n < w; w *= 10 {
+		b = append(b, '0')
+		width--
+	}
+	return strconv.AppendInt(b, int64(n), 10)
+}
+
+// Writes a string that needs no escaping.
+func encodeString(buf *bytes.Buffer, s string) error {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= utf8.RuneSelf || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			return errUnencodable
+		}
+	}
+
+	buf.WriteByte('"')
+	buf.WriteString(s)
+	buf.WriteByte('"')
+
+	return nil
+}
+
+// Appends a float the way encoding/json does, which rejects NaN and the
+// infinities.
+func appendFloat(b []byte, f float64) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errUnencodable
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+
+	// e-09 becomes e-9
+	if n := len(b); format == 'e' && n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+		b[n-2] = b[n-1]
+		b = b[:n-1]
+	}
+
+	return b, nil
+}
@@ -0,0 +1,99 @@
+package panobi
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"cloud.google.com/go/civil"
+)
+
+func Test_marshalMetricItems(t *testing.T) {
+	day := civil.Date{Year: 2023, Month: 1, Day: 1}
+
+	tests := []struct {
+		testName string
+		input    MetricItems
+	}{
+		{
+			testName: "empty",
+			input:    MetricItems{MetricID: "metric", Items: []MetricItem{}},
+		},
+		{
+			testName: "nil items",
+			input:    MetricItems{MetricID: "metric"},
+		},
+		{
+			testName: "values",
+			input: MetricItems{MetricID: "metric", Items: []MetricItem{
+				{Date: day, Value: 0},
+				{Date: day, Value: math.Copysign(0, -1)},
+				{Date: day, Value: 1.5},
+				{Date: day, Value: -123456789.125},
+				{Date: day, Value: 1e20},
+				{Date: day, Value: 1e21},
+				{Date: day, Value: 1e-6},
+				{Date: day, Value: 1e-7},
+				{Date: day, Value: -2.5e-12},
+				{Date: day, Value: math.MaxFloat64},
+				{Date: day, Value: math.SmallestNonzeroFloat64},
+			}},
+		},
+		{
+			testName: "dates",
+			input: MetricItems{MetricID: "metric", Items: []MetricItem{
+				{Date: civil.Date{Year: 1, Month: 2, Day: 3}},
+				{Date: civil.Date{Year: 9999, Month: 12, Day: 31}},
+				{Date: civil.Date{Year: 10000, Month: 1, Day: 1}},
+				{Date: civil.Date{Year: -1, Month: 1, Day: 1}},
+				{Date: civil.Date{}},
+			}},
+		},
+		{
+			testName: "escaped metric ID",
+			input:    MetricItems{MetricID: "a\"b<c>&\né", Items: []MetricItem{{Date: day}}},
+		},
+		{
+			testName: "NaN",
+			input:    MetricItems{MetricID: "metric", Items: []MetricItem{{Date: day, Value: math.NaN()}}},
+		},
+		{
+			testName: "infinity",
+			input:    MetricItems{MetricID: "metric", Items: []MetricItem{{Date: day, Value: math.Inf(-1)}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			want, wantErr := json.Marshal(&tt.input)
+
+			got, err := marshalMetricItems(&tt.input)
+			if (err == nil) != (wantErr == nil) {
+				t.Fatalf("expected err `%v` but got `%v`", wantErr, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("expected `%s` but got `%s`", want, got)
+			}
+		})
+	}
+}
+
+func Test_marshal(t *testing.T) {
+	input := &RequestChartData{
+		MetricID: "metric",
+		Items:    []ChartData{{"a": 1, "b": "<c>"}},
+	}
+
+	want, _ := json.Marshal(input)
+	got, err := marshal(input)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("expected `%s` but got `%s` and `%v`", want, got, err)
+	}
+
+	// the result must not share the pooled buffer
+	again, _ := marshal(&RequestChartData{MetricID: "other"})
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected `%s` to be left alone but got `%s` after encoding `%s`", want, got, again)
+	}
+}
@@ -0,0 +1,135 @@
+package panobi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// the signing key was rejected
+	ErrUnauthorized = errors.New("unauthorized")
+	// the server asked us to slow down, and retries were exhausted
+	ErrRateLimited = errors.New("rate limited")
+	// a batch holds more than MaxItems items
+	ErrBatchTooLarge = errors.New("batch too large")
+	// a payload is larger than the server accepts
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// the local clock is too far from the server's for signatures to be
+	// accepted
+	ErrClockSkew = errors.New("clock skew")
+)
+
+// Returned when the Panobi API responds with a non-2xx status code. Use
+// errors.Is with the sentinel errors above to check the kind of failure, or
+// errors.As to get at the details.
+type APIError struct {
+	StatusCode int    // status code of the last response
+	Message    string // error message from the response body
+	RequestID  string // X-Request-ID sent with the last attempt
+	Endpoint   string // path of the endpoint, without workspace and external IDs
+	Retries    int    // number of retries made before giving up
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("http error %d: %s", e.StatusCode, e.Message)
+}
+
+// Reports whether the error matches one of the sentinel errors.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrPayloadTooLarge:
+		return e.StatusCode == http.StatusRequestEntityTooLarge
+	default:
+		return false
+	}
+}
+
+// The request ID is passed in, as resp.Request is not always set by a custom
+// round tripper.
+func newAPIError(resp *http.Response, body []byte, endpoint apiURI, requestID string) *APIError {
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    parseErrorMessage(body),
+		RequestID:  requestID,
+		Endpoint:   string(endpoint),
+	}
+}
+
+// Returned when a request is rejected as unauthorized and its signature
+// timestamp was too far from the server's clock, which points at the local
+// clock rather than the key. Later requests are timestamped to match the
+// server's clock. It matches both ErrClockSkew and ErrUnauthorized.
+type ClockSkewError struct {
+	Skew time.Duration // server time minus the signature timestamp
+	Err  *APIError
+}
+
+func (e *ClockSkewError) Error() string {
+	return fmt.Sprintf("clock skew of %s: %v", e.Skew.Round(time.Second), e.Err)
+}
+
+func (e *ClockSkewError) Unwrap() []error {
+	return []error{ErrClockSkew, e.Err}
+}
+
+// Extracts the message from a ResponseError body, falling back to the body
+// itself if it is not in that format.
+func parseErrorMessage(body []byte) string {
+	var re struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &re); err == nil && re.Error.Message != "" {
+		return re.Error.Message
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// Error for a limit that is checked locally, before anything is sent. It
+// keeps its own message, but matches the given sentinel error.
+type limitError struct {
+	msg  string
+	kind error
+}
+
+func newLimitError(kind error, name string, max int, unit string) error {
+	return &limitError{
+		msg:  fmt.Sprintf(errMaxNumberSize, name, max, unit),
+		kind: kind,
+	}
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+func (e *limitError) Unwrap() error {
+	return e.kind
+}
+
+// Reports whether the error is a rejection that will not go away by sending
+// the same request again. A rejection caused by clock skew goes away once
+// the skew is corrected for, and a spooled request is sent again anyway.
+func isPermanent(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && !isRetryableStatus(apiErr.StatusCode) &&
+		!errors.Is(err, ErrClockSkew) && !errors.Is(err, ErrSpooled)
+}
+
+// Reports whether the error is a permanent rejection of the request's
+// contents, which is all a spool drops a request for. A key that is
+// rejected may be fixed or rotated, so the request is kept for then.
+func isContentRejection(err error) bool {
+	return isPermanent(err) && !errors.Is(err, ErrUnauthorized)
+}
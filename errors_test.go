@@ -0,0 +1,135 @@
+package panobi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_APIError(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName     string
+		status       int
+		body         string
+		wantSentinel error
+		wantMessage  string
+		wantRetries  int
+	}{
+		{
+			testName:     "unauthorized",
+			status:       http.StatusUnauthorized,
+			body:         `{"error":{"message":"invalid signature"}}`,
+			wantSentinel: ErrUnauthorized,
+			wantMessage:  "invalid signature",
+			wantRetries:  0,
+		},
+		{
+			testName:     "rate limited",
+			status:       http.StatusTooManyRequests,
+			body:         `{"error":{"message":"slow down"}}`,
+			wantSentinel: ErrRateLimited,
+			wantMessage:  "slow down",
+			wantRetries:  2,
+		},
+		{
+			testName:     "payload too large",
+			status:       http.StatusRequestEntityTooLarge,
+			body:         "too big\n",
+			wantSentinel: ErrPayloadTooLarge,
+			wantMessage:  "too big",
+			wantRetries:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var requestID string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestID = r.Header.Get("X-Request-ID")
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			client := CreateClient(ki,
+				WithBaseURL(srv.URL),
+				WithRetryPolicy(RetryPolicy{Attempts: 3, BackoffInitial: time.Millisecond}))
+			err := client.DeleteMetricData("metric")
+
+			if !errors.Is(err, tt.wantSentinel) {
+				t.Errorf("expected err to match `%v` but got `%v`", tt.wantSentinel, err)
+			}
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an APIError but got `%v`", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("expected status code %d but got %d", tt.status, apiErr.StatusCode)
+			}
+			if apiErr.Message != tt.wantMessage {
+				t.Errorf("expected message `%s` but got `%s`", tt.wantMessage, apiErr.Message)
+			}
+			if apiErr.RequestID == "" || apiErr.RequestID != requestID {
+				t.Errorf("expected request ID `%s` but got `%s`", requestID, apiErr.RequestID)
+			}
+			if apiErr.Endpoint != string(DeleteURI) {
+				t.Errorf("expected endpoint `%s` but got `%s`", DeleteURI, apiErr.Endpoint)
+			}
+			if apiErr.Retries != tt.wantRetries {
+				t.Errorf("expected %d retries but got %d", tt.wantRetries, apiErr.Retries)
+			}
+		})
+	}
+}
+
+func Test_APIError_NoRequest(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	// a round tripper need not set the response's Request
+	var requestID string
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requestID = req.Header.Get("X-Request-ID")
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"message":"bad"}}`)),
+		}, nil
+	})
+
+	client := CreateClient(ki, WithRoundTripper(rt))
+	err := client.DeleteMetricData("metric")
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError but got `%v`", err)
+	}
+	if apiErr.RequestID == "" || apiErr.RequestID != requestID {
+		t.Errorf("expected request ID `%s` but got `%s`", requestID, apiErr.RequestID)
+	}
+}
+
+func Test_LimitErrors(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	client := CreateClient(ki, WithBaseURL("http://127.0.0.1:0"))
+
+	err := client.SendMetricItems("metric", make([]MetricItem, MaxItems+1))
+	if !errors.Is(err, ErrBatchTooLarge) {
+		t.Errorf("expected err to match `%v` but got `%v`", ErrBatchTooLarge, err)
+	}
+	if !errorIs("batch cannot be larger than 1000 MetricItems", err) {
+		t.Errorf("unexpected error message `%v`", err)
+	}
+
+	_, err = CalculateSignature(make([]byte, maxInputBytes+1), ki, nil)
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected err to match `%v` but got `%v`", ErrPayloadTooLarge, err)
+	}
+}
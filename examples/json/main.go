@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"log"
@@ -76,24 +77,23 @@ func sendTimeseriesData(file *os.File, client *panobi.Client) {
 	}
 
 	//
-	// send to Panobi in batches
+	// send to Panobi; the client sends several metrics at once and splits
+	// the items into batches
 	//
+	items := make(map[string][]panobi.MetricItem)
 	for _, metric := range metrics {
+		items[metric.MetricID] = append(items[metric.MetricID], metric.Items...)
+	}
 
-		itemCount := len(metric.Items)
-		for i := 0; i < itemCount; i += panobi.MaxItems {
-			rangeEnd := itemCount
-			if i+panobi.MaxItems < itemCount {
-				rangeEnd = i + panobi.MaxItems
-			}
-			err := client.SendMetricItems(metric.MetricID, metric.Items[i:rangeEnd])
-			if err != nil {
-				log.Fatalf("Error sending items for metricID %s: %s", metric.MetricID, err.Error())
-			}
-
-			log.Printf("Successfully sent %d item(s) for metricID %s", len(metric.Items[i:rangeEnd]), metric.MetricID)
+	result, err := client.SendBatch(context.Background(), items)
+	for metricID, r := range result {
+		if r.Err() == nil {
+			log.Printf("Successfully sent %d item(s) for metricID %s", r.Sent(), metricID)
 		}
 	}
+	if err != nil {
+		log.Fatalf("Error sending items: %s", err.Error())
+	}
 }
 
 // sends data for non-timeseries metrics, deleting existing data first
@@ -115,18 +115,11 @@ func sendChartData(file *os.File, client *panobi.Client) {
 		if err != nil {
 			log.Fatalf("Error deleting existing data for metricID %s: %s", metric.MetricID, err.Error())
 		}
-		itemCount := len(metric.Items)
-		for i := 0; i < itemCount; i += panobi.MaxItems {
-			rangeEnd := itemCount
-			if i+panobi.MaxItems < itemCount {
-				rangeEnd = i + panobi.MaxItems
-			}
-			err := client.SendMetricChartData(metric.MetricID, metric.Items[i:rangeEnd])
-			if err != nil {
-				log.Fatalf("Error sending items for metricID %s: %s", metric.MetricID, err.Error())
-			}
-
-			log.Printf("Successfully sent %d item(s) for metricID %s", len(metric.Items[i:rangeEnd]), metric.MetricID)
+		result, err := client.SendAllChartData(context.Background(), metric.MetricID, metric.Items)
+		if err != nil {
+			log.Fatalf("Error sending items for metricID %s after %d item(s): %s", metric.MetricID, result.Sent(), err.Error())
 		}
+
+		log.Printf("Successfully sent %d item(s) for metricID %s", result.Sent(), metric.MetricID)
 	}
 }
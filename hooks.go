@@ -0,0 +1,116 @@
+package panobi
+
+import (
+	"context"
+	"time"
+)
+
+// Describes a request to the Panobi API, which may take several attempts.
+type RequestInfo struct {
+	Endpoint     string // path of the endpoint, without workspace and external IDs
+	MetricID     string
+	Items        int    // number of items or rows in the payload
+	PayloadBytes int    // size of the payload before any compression
+	RequestID    string // X-Request-ID, the same for every attempt
+}
+
+// Describes a single attempt at sending a request.
+type AttemptInfo struct {
+	RequestInfo
+	Attempt    int           // starting at 1
+	StatusCode int           // zero if no response was received
+	Latency    time.Duration // from sending the request until the response was read
+	Err        error         // nil if the attempt succeeded
+}
+
+// Receives notifications about the requests a client makes, so that
+// tracing and metrics adapters can be written without the SDK depending on
+// any telemetry library. Hooks are called synchronously from the goroutine
+// making the request, so they should return quickly. Embed NopHooks to
+// implement only some of the methods.
+type Hooks interface {
+	// Called once per request, before the first attempt. The returned
+	// context is used for the rest of the request, so an adapter can start
+	// a span here and find it again in the other hooks.
+	OnRequestStart(ctx context.Context, info RequestInfo) context.Context
+	// Called before each attempt. Only Attempt is set besides the request.
+	OnAttempt(ctx context.Context, info AttemptInfo)
+	// Called after each attempt that received a response, whatever its
+	// status code.
+	OnResponse(ctx context.Context, info AttemptInfo)
+	// Called when an attempt failed and will be retried after the given
+	// wait.
+	OnRetry(ctx context.Context, info AttemptInfo, wait time.Duration)
+	// Called once, when the request has finally failed.
+	OnError(ctx context.Context, info RequestInfo, err error)
+}
+
+// Implements Hooks by doing nothing.
+type NopHooks struct{}
+
+func (NopHooks) OnRequestStart(ctx context.Context, _ RequestInfo) context.Context {
+	return ctx
+}
+
+func (NopHooks) OnAttempt(context.Context, AttemptInfo)              {}
+func (NopHooks) OnResponse(context.Context, AttemptInfo)             {}
+func (NopHooks) OnRetry(context.Context, AttemptInfo, time.Duration) {}
+func (NopHooks) OnError(context.Context, RequestInfo, error)         {}
+
+// Calls several hooks in turn.
+type multiHooks []Hooks
+
+func (m multiHooks) OnRequestStart(ctx context.Context, info RequestInfo) context.Context {
+	for _, h := range m {
+		ctx = h.OnRequestStart(ctx, info)
+	}
+	return ctx
+}
+
+func (m multiHooks) OnAttempt(ctx context.Context, info AttemptInfo) {
+	for _, h := range m {
+		h.OnAttempt(ctx, info)
+	}
+}
+
+func (m multiHooks) OnResponse(ctx context.Context, info AttemptInfo) {
+	for _, h := range m {
+		h.OnResponse(ctx, info)
+	}
+}
+
+func (m multiHooks) OnRetry(ctx context.Context, info AttemptInfo, wait time.Duration) {
+	for _, h := range m {
+		h.OnRetry(ctx, info, wait)
+	}
+}
+
+func (m multiHooks) OnError(ctx context.Context, info RequestInfo, err error) {
+	for _, h := range m {
+		h.OnError(ctx, info, err)
+	}
+}
+
+func (r *request) info() RequestInfo {
+	return RequestInfo{
+		Endpoint:     string(r.uri),
+		MetricID:     r.metricID,
+		Items:        r.items,
+		PayloadBytes: len(r.body),
+		RequestID:    r.id,
+	}
+}
+
+type traceparentKey struct{}
+
+// Returns a context carrying the given W3C traceparent value, which the
+// client sends as a traceparent header with requests made with the context.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceparentKey{}, traceparent)
+}
+
+// Returns the traceparent value carried by the context, if any.
+func TraceparentFromContext(ctx context.Context) string {
+	tp, _ := ctx.Value(traceparentKey{}).(string)
+	return tp
+}
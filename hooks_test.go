@@ -0,0 +1,101 @@
+package panobi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type spanKey struct{}
+
+type recordingHooks struct {
+	NopHooks
+	events []string
+}
+
+func (h *recordingHooks) OnRequestStart(ctx context.Context, info RequestInfo) context.Context {
+	h.events = append(h.events, fmt.Sprintf("start %s %s %d", info.Endpoint, info.MetricID, info.Items))
+	return context.WithValue(ctx, spanKey{}, "span")
+}
+
+func (h *recordingHooks) OnAttempt(ctx context.Context, info AttemptInfo) {
+	h.events = append(h.events, fmt.Sprintf("attempt %d %v", info.Attempt, ctx.Value(spanKey{})))
+}
+
+func (h *recordingHooks) OnResponse(ctx context.Context, info AttemptInfo) {
+	h.events = append(h.events, fmt.Sprintf("response %d %d", info.Attempt, info.StatusCode))
+}
+
+func (h *recordingHooks) OnRetry(ctx context.Context, info AttemptInfo, wait time.Duration) {
+	h.events = append(h.events, fmt.Sprintf("retry %d", info.Attempt))
+}
+
+func (h *recordingHooks) OnError(ctx context.Context, info RequestInfo, err error) {
+	h.events = append(h.events, fmt.Sprintf("error %v", err))
+}
+
+func Test_Hooks(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		testName   string
+		statuses   []int
+		wantEvents []string
+	}{
+		{
+			testName: "retried then accepted",
+			statuses: []int{503, 200},
+			wantEvents: []string{
+				"start /integrations/metrics-sdk/timeseries metric 2",
+				"attempt 1 span",
+				"response 1 503",
+				"retry 1",
+				"attempt 2 span",
+				"response 2 200",
+			},
+		},
+		{
+			testName: "rejected",
+			statuses: []int{400},
+			wantEvents: []string{
+				"start /integrations/metrics-sdk/timeseries metric 2",
+				"attempt 1 span",
+				"response 1 400",
+				"error http error 400: bad",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("traceparent"); got != traceparent {
+					t.Errorf("expected traceparent `%s` but got `%s`", traceparent, got)
+				}
+				w.WriteHeader(tt.statuses[calls])
+				w.Write([]byte("bad"))
+				calls++
+			}))
+			defer srv.Close()
+
+			h := &recordingHooks{}
+			client := CreateClient(ki,
+				WithBaseURL(srv.URL),
+				WithHooks(h),
+				WithRetryPolicy(RetryPolicy{Attempts: 3, BackoffInitial: time.Millisecond}))
+
+			ctx := ContextWithTraceparent(context.Background(), traceparent)
+			client.SendMetricItemsContext(ctx, "metric", make([]MetricItem, 2))
+
+			if got, want := strings.Join(h.events, "\n"), strings.Join(tt.wantEvents, "\n"); got != want {
+				t.Errorf("expected events\n%s\nbut got\n%s", want, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package panobi
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"strings"
 )
@@ -10,11 +12,22 @@ const (
 	errInvalidKey string = "invalid key"
 )
 
-// Holds information about a signing key.
+// Holds information about a signing key. The secret part of the key is
+// never printed, logged or marshalled; use Reveal to get at it.
 type KeyInfo struct {
-	K           string // actual key
 	WorkspaceID string // workspace ID
 	ExternalID  string // external ID
+
+	k []byte // actual key
+}
+
+// Creates key information from its component parts. The secret is copied.
+func NewKeyInfo(workspaceID, externalID string, secret []byte) KeyInfo {
+	return KeyInfo{
+		WorkspaceID: workspaceID,
+		ExternalID:  externalID,
+		k:           append([]byte(nil), secret...),
+	}
 }
 
 // Parses the given string, and returns a KeyInfo structure holding the
@@ -46,15 +59,69 @@ func ParseKey(input string) (KeyInfo, error) {
 	}
 
 	return KeyInfo{
-		K:           k,
 		WorkspaceID: workspaceID,
 		ExternalID:  externalID,
+		k:           []byte(k),
 	}, nil
 }
 
-// Test for equality against the given key information.
+// Returns the secret part of the key, for signing. The returned slice is
+// shared with the KeyInfo and its copies, so it must not be modified or
+// kept.
+func (ki KeyInfo) Reveal() []byte {
+	return ki.k
+}
+
+// Zeroes the secret part of the key, in this KeyInfo and every copy of it,
+// so that it does not linger in memory once it is no longer needed.
+func (ki KeyInfo) Destroy() {
+	for i := range ki.k {
+		ki.k[i] = 0
+	}
+}
+
+// Returns a KeyInfo with its own copy of the secret, which is not affected
+// when the original is destroyed.
+func (ki KeyInfo) clone() KeyInfo {
+	return NewKeyInfo(ki.WorkspaceID, ki.ExternalID, ki.k)
+}
+
+// Test for equality against the given key information. The secrets are
+// compared in constant time.
 func (ki KeyInfo) Equals(other KeyInfo) bool {
-	return ki.K == other.K &&
+	return subtle.ConstantTimeCompare(ki.k, other.k) == 1 &&
 		ki.WorkspaceID == other.WorkspaceID &&
 		ki.ExternalID == other.ExternalID
 }
+
+// Returns the key in its usual `W-E-K` format, with the secret redacted.
+func (ki KeyInfo) String() string {
+	return ki.WorkspaceID + "-" + ki.ExternalID + "-" + redacted
+}
+
+func (ki KeyInfo) GoString() string {
+	return fmt.Sprintf("panobi.KeyInfo{WorkspaceID:%q, ExternalID:%q, k:%s}", ki.WorkspaceID, ki.ExternalID, redacted)
+}
+
+// Formats the key with the secret redacted, whatever the verb.
+func (ki KeyInfo) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprint(f, ki.GoString())
+	case verb == 'v' && f.Flag('+'):
+		fmt.Fprintf(f, "{WorkspaceID:%s ExternalID:%s k:%s}", ki.WorkspaceID, ki.ExternalID, redacted)
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", ki.String())
+	default:
+		fmt.Fprint(f, ki.String())
+	}
+}
+
+// Marshals the workspace and external IDs, but never the secret.
+func (ki KeyInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		WorkspaceID string `json:"workspaceID"`
+		ExternalID  string `json:"externalID"`
+		Key         string `json:"key"`
+	}{ki.WorkspaceID, ki.ExternalID, redacted})
+}
@@ -0,0 +1,14 @@
+//go:build go1.21
+
+package panobi
+
+import "log/slog"
+
+// Logs the workspace and external IDs with a *slog.Logger, with the secret
+// redacted.
+func (ki KeyInfo) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("workspace_id", ki.WorkspaceID),
+		slog.String("external_id", ki.ExternalID),
+		slog.String("key", redacted))
+}
@@ -0,0 +1,21 @@
+//go:build go1.21
+
+package panobi
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func Test_KeyInfo_LogValue(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-secret")
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("sending", "key", ki)
+
+	if got := buf.String(); strings.Contains(got, "secret") || !strings.Contains(got, "key.key=[REDACTED]") {
+		t.Errorf("expected the key to be redacted but got `%s`", got)
+	}
+}
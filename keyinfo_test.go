@@ -1,6 +1,9 @@
 package panobi
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"testing"
 )
 
@@ -41,7 +44,7 @@ func Test_ParseKey(t *testing.T) {
 			wantKeyInfo: KeyInfo{
 				WorkspaceID: "1234567890123456789012",
 				ExternalID:  "1234567890123456789012",
-				K:           "def",
+				k:           []byte("def"),
 			},
 			wantErr: "",
 		},
@@ -59,3 +62,76 @@ func Test_ParseKey(t *testing.T) {
 		})
 	}
 }
+
+func Test_KeyInfo_Redacted(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-secret")
+	ids := "1234567890123456789012"
+
+	tests := []struct {
+		testName string
+		format   func() string
+		want     string
+	}{
+		{
+			testName: "%v",
+			format:   func() string { return fmt.Sprintf("%v", ki) },
+			want:     ids + "-" + ids + "-[REDACTED]",
+		},
+		{
+			testName: "%s",
+			format:   func() string { return fmt.Sprintf("%s", ki) },
+			want:     ids + "-" + ids + "-[REDACTED]",
+		},
+		{
+			testName: "%q",
+			format:   func() string { return fmt.Sprintf("%q", ki) },
+			want:     `"` + ids + "-" + ids + `-[REDACTED]"`,
+		},
+		{
+			testName: "%+v",
+			format:   func() string { return fmt.Sprintf("%+v", ki) },
+			want:     "{WorkspaceID:" + ids + " ExternalID:" + ids + " k:[REDACTED]}",
+		},
+		{
+			testName: "%#v",
+			format:   func() string { return fmt.Sprintf("%#v", ki) },
+			want:     `panobi.KeyInfo{WorkspaceID:"` + ids + `", ExternalID:"` + ids + `", k:[REDACTED]}`,
+		},
+		{
+			testName: "nested %+v",
+			format:   func() string { return fmt.Sprintf("%+v", struct{ Key KeyInfo }{ki}) },
+			want:     "{Key:{WorkspaceID:" + ids + " ExternalID:" + ids + " k:[REDACTED]}}",
+		},
+		{
+			testName: "JSON",
+			format: func() string {
+				b, _ := json.Marshal(ki)
+				return string(b)
+			},
+			want: `{"workspaceID":"` + ids + `","externalID":"` + ids + `","key":"[REDACTED]"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := tt.format(); got != tt.want {
+				t.Errorf("expected `%s` but got `%s`", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_KeyInfo_Destroy(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-secret")
+	clone := ki.clone()
+	copied := ki
+
+	ki.Destroy()
+
+	if !bytes.Equal(copied.Reveal(), make([]byte, len("secret"))) {
+		t.Errorf("expected copies to be zeroed but got `%s`", copied.Reveal())
+	}
+	if string(clone.Reveal()) != "secret" {
+		t.Errorf("expected a clone to be unaffected but got `%s`", clone.Reveal())
+	}
+}
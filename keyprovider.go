@@ -0,0 +1,123 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// no key is available from a provider
+var ErrNoKey = errors.New("no signing key")
+
+// Supplies the signing key. A client asks its provider once per request, so
+// a rotated key is picked up without a restart, while every attempt at the
+// same request is signed with the same key. Implementations must be safe
+// for concurrent use, and must not destroy or change a key once it has
+// been handed out.
+type KeyProvider interface {
+	Key(ctx context.Context) (KeyInfo, error)
+}
+
+// Adapts a function to the KeyProvider interface.
+type KeyProviderFunc func(ctx context.Context) (KeyInfo, error)
+
+func (f KeyProviderFunc) Key(ctx context.Context) (KeyInfo, error) {
+	return f(ctx)
+}
+
+// Returns a provider that always supplies the given key.
+func StaticKeyProvider(ki KeyInfo) KeyProvider {
+	return KeyProviderFunc(func(context.Context) (KeyInfo, error) {
+		return ki, nil
+	})
+}
+
+// Returns a provider that parses the key from the named environment
+// variable, such as METRICS_SDK_SIGNING_KEY, each time it is asked.
+func EnvKeyProvider(name string) KeyProvider {
+	return KeyProviderFunc(func(context.Context) (KeyInfo, error) {
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			return KeyInfo{}, fmt.Errorf("%w: %s is not set", ErrNoKey, name)
+		}
+
+		ki, err := ParseKey(s)
+		if err != nil {
+			return KeyInfo{}, fmt.Errorf("%s: %w", name, err)
+		}
+
+		return ki, nil
+	})
+}
+
+// Returns a provider that asks each of the given providers in turn, and
+// supplies the first key found.
+func ChainKeyProvider(providers ...KeyProvider) KeyProvider {
+	return KeyProviderFunc(func(ctx context.Context) (KeyInfo, error) {
+		errs := []error{ErrNoKey}
+		for _, p := range providers {
+			ki, err := p.Key(ctx)
+			if err == nil {
+				return ki, nil
+			}
+			errs = append(errs, err)
+		}
+
+		return KeyInfo{}, errors.Join(errs...)
+	})
+}
+
+// Supplies the key held in a file, such as a Kubernetes or Docker secret
+// mount. The file is read again whenever its size or modification time
+// changes, so a rotated secret is picked up without a restart. The key it
+// replaces is destroyed; every caller gets its own copy of the key, so
+// copies already handed out are not affected.
+type FileKeyProvider struct {
+	path string
+
+	mu      sync.Mutex
+	ki      KeyInfo
+	size    int64
+	modTime time.Time
+}
+
+// Creates a provider for the key held in the given file. The file is not
+// read until a key is needed.
+func NewFileKeyProvider(path string) *FileKeyProvider {
+	return &FileKeyProvider{path: path}
+}
+
+func (p *FileKeyProvider) Key(context.Context) (KeyInfo, error) {
+	// secret mounts swap in a new file through a symlink, which Stat
+	// follows
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.ki.Reveal()) > 0 && info.Size() == p.size && info.ModTime().Equal(p.modTime) {
+		return p.ki.clone(), nil
+	}
+
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	ki, err := ParseKey(strings.TrimSpace(string(b)))
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("%s: %w", p.path, err)
+	}
+
+	p.ki.Destroy()
+	p.ki, p.size, p.modTime = ki, info.Size(), info.ModTime()
+
+	return ki.clone(), nil
+}
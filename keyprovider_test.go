@@ -0,0 +1,201 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_EnvKeyProvider(t *testing.T) {
+	tests := []struct {
+		testName string
+		value    *string
+		wantKey  string
+		wantErr  error
+	}{
+		{
+			testName: "set",
+			value:    ptr("1234567890123456789012-1234567890123456789012-123"),
+			wantKey:  "123",
+		},
+		{
+			testName: "not set",
+			wantErr:  ErrNoKey,
+		},
+		{
+			testName: "invalid",
+			value:    ptr("abc"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if tt.value != nil {
+				t.Setenv("PANOBI_TEST_KEY", *tt.value)
+			}
+
+			ki, err := EnvKeyProvider("PANOBI_TEST_KEY").Key(context.Background())
+			if tt.wantKey == "" && err == nil {
+				t.Fatalf("expected an error but got key `%s`", string(ki.Reveal()))
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected err to match `%v` but got `%v`", tt.wantErr, err)
+			}
+			if string(ki.Reveal()) != tt.wantKey {
+				t.Errorf("expected key `%s` but got `%s`", tt.wantKey, string(ki.Reveal()))
+			}
+		})
+	}
+}
+
+func Test_ChainKeyProvider(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	missing := EnvKeyProvider("PANOBI_TEST_KEY_MISSING")
+
+	got, err := ChainKeyProvider(missing, StaticKeyProvider(ki)).Key(context.Background())
+	if err != nil || !got.Equals(ki) {
+		t.Errorf("expected the second provider's key but got `%v`", err)
+	}
+
+	if _, err := ChainKeyProvider(missing).Key(context.Background()); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected err to match `%v` but got `%v`", ErrNoKey, err)
+	}
+}
+
+func Test_FileKeyProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	p := NewFileKeyProvider(path)
+
+	if _, err := p.Key(context.Background()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected err to match `%v` but got `%v`", os.ErrNotExist, err)
+	}
+
+	write := func(key string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+			t.Fatalf("unexpected error writing key: %v", err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	now := time.Now()
+	write("1234567890123456789012-1234567890123456789012-123", now)
+	if ki, err := p.Key(context.Background()); err != nil || string(ki.Reveal()) != "123" {
+		t.Errorf("expected key `123` but got `%s` and `%v`", string(ki.Reveal()), err)
+	}
+
+	write("1234567890123456789012-1234567890123456789012-456", now.Add(time.Second))
+	if ki, err := p.Key(context.Background()); err != nil || string(ki.Reveal()) != "456" {
+		t.Errorf("expected the rotated key `456` but got `%s` and `%v`", string(ki.Reveal()), err)
+	}
+}
+
+func Test_FileKeyProvider_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	p := NewFileKeyProvider(path)
+
+	write := func(key string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+			t.Fatalf("unexpected error writing key: %v", err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	now := time.Now()
+	write("1234567890123456789012-1234567890123456789012-123", now)
+
+	// keys handed out stay intact while the file is rotated under them
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				ki, err := p.Key(context.Background())
+				if err != nil {
+					// the file may be caught half written
+					continue
+				}
+				if k := string(ki.clone().Reveal()); k != "123" && k != "456" {
+					t.Errorf("expected key `123` or `456` but got %q", k)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 50; i++ {
+		key := "1234567890123456789012-1234567890123456789012-123"
+		if i%2 == 1 {
+			key = "1234567890123456789012-1234567890123456789012-456"
+		}
+		write(key, now.Add(time.Duration(i)*time.Second))
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func Test_Client_KeyProvider(t *testing.T) {
+	first, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	second, _ := ParseKey("1234567890123456789012-1234567890123456789012-456")
+
+	// every call to the provider rotates the key
+	calls := 0
+	provider := KeyProviderFunc(func(context.Context) (KeyInfo, error) {
+		calls++
+		if calls%2 == 1 {
+			return first, nil
+		}
+		return second, nil
+	})
+
+	var verified []string
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		sig, ts := r.Header.Get("X-Panobi-Signature"), r.Header.Get("X-Panobi-Request-Timestamp")
+		for _, ki := range []KeyInfo{first, second} {
+			if VerifySignature(b, sig, ts, ki, 0) == nil {
+				verified = append(verified, string(ki.Reveal()))
+			}
+		}
+
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	client := CreateClient(KeyInfo{},
+		WithBaseURL(srv.URL),
+		WithKeyProvider(provider),
+		WithRetryPolicy(RetryPolicy{Attempts: 2, BackoffInitial: time.Millisecond}))
+	defer client.Close()
+
+	// a retried request keeps its key, and the next request gets a new one
+	client.DeleteMetricData("metric")
+	client.DeleteMetricData("metric")
+
+	want := []string{"123", "123", "456"}
+	if len(verified) != len(want) || verified[0] != want[0] || verified[1] != want[1] || verified[2] != want[2] {
+		t.Errorf("expected requests signed with %v but got %v", want, verified)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
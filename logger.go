@@ -0,0 +1,91 @@
+package panobi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+const (
+	redacted string = "[REDACTED]"
+)
+
+// Receives log messages from a client. Arguments after the message are
+// alternating keys and values. A *slog.Logger satisfies this interface, and
+// adapters for other logging libraries are straightforward to write.
+//
+// Retries, waits and failures that are handled internally are logged at
+// Info or Warn; every response is logged at Debug.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// Logs every request and response passing through at Debug. Requests are
+// dumped as signed but before compression.
+func (t *transport) dumpRoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, err := readBody(req)
+		if err != nil {
+			return nil, err
+		}
+		t.dumpRequest(req, body)
+
+		resp, err := next.RoundTrip(req)
+		if err == nil {
+			t.dumpResponse(resp, signingKey(req.Context()))
+		}
+
+		return resp, err
+	})
+}
+
+func (t *transport) dumpRequest(req *http.Request, body []byte) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\r\n", req.Method, req.URL, req.Proto)
+	redactHeaders(req.Header).Write(&b)
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	t.log.Debug("panobi: request dump", "dump", redact(b.String(), signingKey(req.Context())))
+}
+
+// The body is left intact for the caller to read.
+func (t *transport) dumpResponse(resp *http.Response, ki KeyInfo) {
+	b, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.log.Debug("panobi: cannot dump response", "error", err)
+		return
+	}
+
+	t.log.Debug("panobi: response dump", "dump", redact(string(b), ki))
+}
+
+// Removes the signing key from the given text, in case it shows up.
+func redact(s string, ki KeyInfo) string {
+	if len(ki.Reveal()) == 0 {
+		return s
+	}
+
+	return strings.ReplaceAll(s, string(ki.Reveal()), redacted)
+}
+
+// Returns a copy of the headers with the signature removed.
+func redactHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	if h.Get("X-Panobi-Signature") != "" {
+		h.Set("X-Panobi-Signature", redacted)
+	}
+
+	return h
+}
@@ -0,0 +1,86 @@
+package panobi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) log(level, msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *testLogger) Debug(msg string, args ...any) { l.log("DEBUG", msg, args...) }
+func (l *testLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args...) }
+func (l *testLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args...) }
+func (l *testLogger) Error(msg string, args ...any) { l.log("ERROR", msg, args...) }
+
+func (l *testLogger) find(prefix string) []string {
+	var found []string
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, prefix) {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+func Test_Logger(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-supersecretkey")
+
+	var signature string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Panobi-Signature")
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		// a misbehaving server echoing the key back
+		w.Write([]byte(`{"echo":"supersecretkey"}`))
+	}))
+	defer srv.Close()
+
+	l := &testLogger{}
+	client := CreateClient(ki,
+		WithBaseURL(srv.URL),
+		WithLogger(l),
+		WithDebugDump(),
+		WithRetryPolicy(RetryPolicy{Attempts: 2, BackoffInitial: time.Millisecond}))
+	if err := client.DeleteMetricData("metric"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := len(l.find("INFO panobi: retrying request")); got != 1 {
+		t.Errorf("expected 1 retry to be logged but got %d", got)
+	}
+	if got := len(l.find("DEBUG panobi: response [")); got != 2 {
+		t.Errorf("expected 2 responses to be logged but got %d", got)
+	}
+	if got := len(l.find("DEBUG panobi: request dump")); got != 2 {
+		t.Errorf("expected 2 request dumps but got %d", got)
+	}
+	if got := len(l.find("DEBUG panobi: response dump")); got != 2 {
+		t.Errorf("expected 2 response dumps but got %d", got)
+	}
+
+	for _, line := range l.lines {
+		if strings.Contains(line, "supersecretkey") || strings.Contains(line, signature) {
+			t.Errorf("expected secrets to be redacted but got `%s`", line)
+		}
+	}
+	if dumps := l.find("DEBUG panobi: request dump"); len(dumps) == 0 || !strings.Contains(dumps[0], "X-Panobi-Signature: "+redacted) {
+		t.Errorf("expected the signature header to be redacted in %v", dumps)
+	}
+}
@@ -0,0 +1,240 @@
+package panobi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Configures a Client. Pass any number of options to CreateClient.
+type Option func(*options)
+
+type options struct {
+	baseURL      string
+	httpClient   *http.Client
+	roundTripper http.RoundTripper
+	middleware   []Middleware
+	keys         KeyProvider
+	timeout      time.Duration
+	retry        RetryPolicy
+	concurrency  int
+	rateLimit    float64
+	burst        int
+	gzip         bool
+
+	stableRequestIDs bool
+	requestIDScope   string
+	signatureVersion SignatureVersion
+	spool            *Spool
+	deadLetters      *DeadLetterWriter
+	bisect           bool
+	logger           Logger
+	debugDump        bool
+	hooks            []Hooks
+	traceparent      func(context.Context) string
+}
+
+func defaultOptions() options {
+	return options{
+		baseURL:     DefaultBaseURL,
+		retry:       DefaultRetryPolicy(),
+		concurrency: defaultConcurrency,
+		logger:      nopLogger{},
+		traceparent: TraceparentFromContext,
+	}
+}
+
+// Sends requests to the given base URL instead of DefaultBaseURL. This is
+// useful for staging or regional endpoints, and for local stand-ins such as
+// an httptest server.
+func WithBaseURL(baseURL string) Option {
+	return func(o *options) {
+		o.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// Sends requests with the given HTTP client instead of a default one.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = c
+	}
+}
+
+// Sends requests through the given round tripper. If combined with
+// WithHTTPClient, the round tripper replaces the client's transport.
+func WithRoundTripper(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.roundTripper = rt
+	}
+}
+
+// Wraps the round tripper every attempt goes through. May be given more
+// than once; the first middleware given is the outermost. Middleware sees
+// each attempt before it is signed, so it may add headers or change the
+// body.
+func WithMiddleware(m Middleware) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, m)
+	}
+}
+
+// Asks the given provider for the signing key before each request, instead
+// of using the key given to CreateClient, so that a rotated key is picked up
+// without a restart.
+func WithKeyProvider(p KeyProvider) Option {
+	return func(o *options) {
+		o.keys = p
+	}
+}
+
+// Signs requests with the given version of signature. The default is
+// SignatureV0; SignatureV1 also covers the endpoint and request ID.
+func WithSignatureVersion(v SignatureVersion) Option {
+	return func(o *options) {
+		o.signatureVersion = v
+	}
+}
+
+// Bounds each HTTP attempt, including reading the response, by the given
+// duration. Waits between retries are not included. Zero means no timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Retries requests according to the given policy instead of
+// DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
+
+// Bounds the number of metrics that SendBatch and SendChartDataBatch send at
+// the same time. Defaults to 4.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			n = 1
+		}
+		o.concurrency = n
+	}
+}
+
+// Limits requests made by the client, across all goroutines, to the given
+// number per second, allowing short bursts of up to burst requests. By
+// default requests are only held back when the server responds with 429.
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(o *options) {
+		o.rateLimit = perSecond
+		o.burst = burst
+	}
+}
+
+// Compresses request bodies with gzip and sends them with a
+// Content-Encoding header. The signature is calculated over the
+// uncompressed body, so it is the same with or without compression.
+func WithGzip() Option {
+	return func(o *options) {
+		o.gzip = true
+	}
+}
+
+// Derives each request ID from the endpoint, the key's workspace and
+// external IDs, the metric ID, the scope given to WithRequestIDScope and a
+// hash of the payload, instead of picking one at random. Sending the same
+// data again, for example when re-running a job that crashed, then produces
+// the same request IDs, which lets the server recognise duplicates.
+//
+// Beware that the server may then also drop data that is sent again on
+// purpose: chart data sent again after deleting it, for example, or the
+// same data sent by tomorrow's run of a job. Give each run its own scope
+// to tell them apart. Deletes always get random request IDs, as their
+// payload is just the metric ID.
+func WithStableRequestIDs() Option {
+	return func(o *options) {
+		o.stableRequestIDs = true
+	}
+}
+
+// Mixes the given scope, such as the date a job runs for, into stable
+// request IDs, so that the same data sent in another scope gets different
+// request IDs. A re-run of the job must use the same scope.
+func WithRequestIDScope(scope string) Option {
+	return func(o *options) {
+		o.requestIDScope = scope
+	}
+}
+
+// Writes every request to the given spool before sending it, so that
+// requests which cannot be sent are kept and sent again later. Unless the
+// spool was opened with a zero drain interval, the client sends spooled
+// requests in the background until Close is called.
+func WithSpool(s *Spool) Option {
+	return func(o *options) {
+		o.spool = s
+	}
+}
+
+// Writes requests that are permanently rejected by the Panobi API, such as
+// those getting a 400 response, to the given dead-letter writer, so that
+// they can be inspected and sent again once the problem is fixed.
+func WithDeadLetters(w *DeadLetterWriter) Option {
+	return func(o *options) {
+		o.deadLetters = w
+	}
+}
+
+// When the server rejects a batch of timeseries items or chart data rows
+// with a 400 or 422 response, splits the batch in halves and sends them
+// again, recursively, to find the items that caused the rejection. The
+// other items are stored, and the error is a *RejectedItemsError listing
+// the rejected items. Only the rejected items are written as dead letters.
+// If both halves are rejected the same way as the batch, the rejection is
+// taken to be about the batch as a whole and returned as is. At most 128
+// requests are made to bisect a batch; halves still rejected after that
+// are reported as rejected in full.
+func WithBisect() Option {
+	return func(o *options) {
+		o.bisect = true
+	}
+}
+
+// Sends log messages about retries, waits and responses to the given
+// logger, such as a *slog.Logger. By default nothing is logged.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		if l == nil {
+			l = nopLogger{}
+		}
+		o.logger = l
+	}
+}
+
+// Logs a dump of every request and response at Debug level. The signature
+// header and the signing key are redacted, but request bodies are logged
+// in full.
+func WithDebugDump() Option {
+	return func(o *options) {
+		o.debugDump = true
+	}
+}
+
+// Calls the given hooks as requests are made. May be given more than once;
+// hooks are called in the order they were given.
+func WithHooks(h Hooks) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, h)
+	}
+}
+
+// Sends the value returned by the given function as a W3C traceparent
+// header with each request. By default the value set with
+// ContextWithTraceparent is sent, if there is one.
+func WithTraceparent(f func(context.Context) string) Option {
+	return func(o *options) {
+		o.traceparent = f
+	}
+}
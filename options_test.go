@@ -0,0 +1,84 @@
+package panobi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_CreateClient_Options(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName  string
+		status    int
+		delay     time.Duration
+		opts      []Option
+		wantCalls int32
+		wantErr   bool
+	}{
+		{
+			testName:  "base URL",
+			status:    http.StatusOK,
+			wantCalls: 1,
+		},
+		{
+			testName: "round tripper",
+			status:   http.StatusOK,
+			opts: []Option{WithRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				r.Header.Set("X-Test", "1")
+				return http.DefaultTransport.RoundTrip(r)
+			}))},
+			wantCalls: 1,
+		},
+		{
+			testName: "retry policy",
+			status:   http.StatusTooManyRequests,
+			opts: []Option{WithRetryPolicy(RetryPolicy{
+				Attempts:          4,
+				BackoffInitial:    time.Millisecond,
+				BackoffMultiplier: 1,
+			})},
+			wantCalls: 4,
+			wantErr:   true,
+		},
+		{
+			testName:  "timeout",
+			status:    http.StatusOK,
+			delay:     500 * time.Millisecond,
+			opts:      []Option{WithTimeout(100 * time.Millisecond), WithRetryPolicy(RetryPolicy{Attempts: 1})},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if r.URL.Path != "/integrations/metrics-sdk/delete/"+ki.WorkspaceID+"/"+ki.ExternalID {
+					t.Errorf("unexpected path `%s`", r.URL.Path)
+				}
+				if tt.testName == "round tripper" && r.Header.Get("X-Test") != "1" {
+					t.Errorf("expected round tripper to be used")
+				}
+				time.Sleep(tt.delay)
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			client := CreateClient(ki, append([]Option{WithBaseURL(srv.URL + "/")}, tt.opts...)...)
+			err := client.DeleteMetricData("metric")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %t but got `%v`", tt.wantErr, err)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("expected %d call(s) but got %d", tt.wantCalls, got)
+			}
+		})
+	}
+}
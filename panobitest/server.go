@@ -0,0 +1,450 @@
+// Package panobitest provides a fake Panobi API, for testing code built on
+// the metrics SDK without sending anything to Panobi.
+package panobitest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/civil"
+	panobi "github.com/panobi/metrics-sdk"
+)
+
+// A fake Panobi API, serving the timeseries, chart data and delete
+// endpoints. Requests must be signed with the server's key, and are
+// verified the same way the Panobi API verifies them.
+//
+// Timeseries items are only stored for dates the metric has no item for
+// yet; items for dates already stored are ignored. Chart data rows are
+// added to those already stored, so chart data is replaced by deleting the
+// metric's data and sending the new rows.
+type Server struct {
+	URL string // base URL of the server, for panobi.WithBaseURL
+
+	key    panobi.KeyInfo
+	verify []panobi.VerifyOption
+	srv    *httptest.Server
+
+	mu         sync.Mutex
+	timeseries map[string]map[civil.Date]float64
+	chartData  map[string][]panobi.ChartData
+	faults     []Fault
+	requests   []Request
+}
+
+// Configures a server.
+type Option func(*Server)
+
+// Sets the key that requests must be signed with. By default, the server
+// makes up a key of its own.
+func WithKey(ki panobi.KeyInfo) Option {
+	return func(s *Server) {
+		s.key = ki
+	}
+}
+
+// Sets the options the server verifies signatures with, such as
+// panobi.WithRequiredSignatureVersion.
+func WithVerifyOptions(opts ...panobi.VerifyOption) Option {
+	return func(s *Server) {
+		s.verify = append(s.verify, opts...)
+	}
+}
+
+// Makes the server misbehave for the next requests, instead of storing
+// what they send.
+type Fault struct {
+	Endpoint string // only requests to this endpoint, such as string(panobi.TimeseriesURI); any if empty
+
+	Status     int           // status code to respond with, such as 429 or 503; if 0, the request is handled as usual after any latency
+	RetryAfter time.Duration // sent in the Retry-After header, rounded up to whole seconds; 0 for a 429 unless set
+	Latency    time.Duration // delay before responding, cut short if the client gives up
+	Malformed  bool          // respond with a body that is not JSON, instead of a ResponseError
+
+	Times int // how many requests it applies to; 1 if 0
+}
+
+// A request the server has received.
+type Request struct {
+	Endpoint  string // such as string(panobi.TimeseriesURI)
+	RequestID string // X-Request-ID
+	Status    int    // status code of the response; 0 if the client gave up first
+}
+
+// Starts a server, which must be closed once done with.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		timeseries: make(map[string]map[civil.Date]float64),
+		chartData:  make(map[string][]panobi.ChartData),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.key.WorkspaceID == "" {
+		s.key = newKey()
+	}
+
+	api := http.NewServeMux()
+	// any workspace and external IDs match, as the signature check makes
+	// sure they are the server's
+	api.HandleFunc(string(panobi.TimeseriesURI)+"/", s.handleTimeseries)
+	api.HandleFunc(string(panobi.ChartDataURI)+"/", s.handleChartData)
+	api.HandleFunc(string(panobi.DeleteURI)+"/", s.handleDelete)
+
+	s.srv = httptest.NewServer(s.record(s.inject(
+		panobi.VerifyRequests([]panobi.KeyInfo{s.key}, api, s.verify...))))
+	s.URL = s.srv.URL
+
+	return s
+}
+
+// Shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Returns the key that requests must be signed with.
+func (s *Server) Key() panobi.KeyInfo {
+	return s.key
+}
+
+// Creates a client that sends to the server, signing with its key. The
+// given options are applied after the base URL.
+func (s *Server) Client(opts ...panobi.Option) *panobi.Client {
+	return panobi.CreateClient(s.key, append([]panobi.Option{panobi.WithBaseURL(s.URL)}, opts...)...)
+}
+
+// Makes the server misbehave for the next requests. Faults apply in the
+// order given, each to as many requests as it says.
+func (s *Server) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range faults {
+		if f.Times <= 0 {
+			f.Times = 1
+		}
+		s.faults = append(s.faults, f)
+	}
+}
+
+// Returns the timeseries items stored for the metric, in date order.
+func (s *Server) Timeseries(metricID string) []panobi.MetricItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]panobi.MetricItem, 0, len(s.timeseries[metricID]))
+	for date, value := range s.timeseries[metricID] {
+		items = append(items, panobi.MetricItem{Date: date, Value: value})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Date.Before(items[j].Date)
+	})
+
+	return items
+}
+
+// Returns the chart data rows stored for the metric, in the order they
+// were received. Numbers are float64, as decoded from JSON.
+func (s *Server) ChartData(metricID string) []panobi.ChartData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]panobi.ChartData(nil), s.chartData[metricID]...)
+}
+
+// Returns every request the server has received, including rejected
+// ones, in the order they were received.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// Discards everything stored, along with pending faults and received
+// requests.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timeseries = make(map[string]map[civil.Date]float64)
+	s.chartData = make(map[string][]panobi.ChartData)
+	s.faults = nil
+	s.requests = nil
+}
+
+func (s *Server) handleTimeseries(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MetricID string `json:"metricID"`
+		Items    []struct {
+			Date  *string  `json:"date"`
+			Value *float64 `json:"value"`
+		} `json:"items"`
+	}
+	if !decode(w, r, &req) || !validate(w, req.MetricID, req.Items != nil, len(req.Items)) {
+		return
+	}
+
+	items := make([]panobi.MetricItem, len(req.Items))
+	for i, item := range req.Items {
+		if item.Date == nil || item.Value == nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("item %d: date and value are required", i))
+			return
+		}
+		date, err := civil.ParseDate(*item.Date)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("item %d: %v", i, err))
+			return
+		}
+		items[i] = panobi.MetricItem{Date: date, Value: *item.Value}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.timeseries[req.MetricID]
+	if stored == nil {
+		stored = make(map[civil.Date]float64, len(items))
+		s.timeseries[req.MetricID] = stored
+	}
+	for _, item := range items {
+		if _, ok := stored[item.Date]; !ok {
+			stored[item.Date] = item.Value
+		}
+	}
+}
+
+func (s *Server) handleChartData(w http.ResponseWriter, r *http.Request) {
+	var req panobi.RequestChartData
+	if !decode(w, r, &req) || !validate(w, req.MetricID, req.Items != nil, len(req.Items)) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chartData[req.MetricID] = append(s.chartData[req.MetricID], req.Items...)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req panobi.RequestMetricDataDelete
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.MetricID == "" {
+		writeError(w, http.StatusBadRequest, "metricID is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.timeseries, req.MetricID)
+	delete(s.chartData, req.MetricID)
+}
+
+// Decodes the request body into v. Rejects the request and returns false
+// if it cannot.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("malformed body: %v", err))
+		return false
+	}
+
+	return true
+}
+
+// Checks that a request names a metric and has a batch of items the API
+// accepts. Rejects the request and returns false if not.
+func validate(w http.ResponseWriter, metricID string, hasItems bool, items int) bool {
+	switch {
+	case metricID == "":
+		writeError(w, http.StatusBadRequest, "metricID is required")
+	case !hasItems:
+		writeError(w, http.StatusBadRequest, "items are required")
+	case items > panobi.MaxItems:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("items cannot be more than %d", panobi.MaxItems))
+	default:
+		return true
+	}
+
+	return false
+}
+
+// Applies the next pending fault that matches the request, if there is
+// one.
+func (s *Server) inject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := s.nextFault(endpointOf(r))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f.Latency > 0 {
+			// the server only notices the client giving up once the body
+			// has been read
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			timer := time.NewTimer(f.Latency)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if f.Status == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(f.RetryAfter.Seconds()))))
+		} else if f.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+
+		if f.Malformed {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(f.Status)
+			fmt.Fprint(w, malformed)
+			return
+		}
+
+		writeError(w, f.Status, http.StatusText(f.Status))
+	})
+}
+
+func (s *Server) nextFault(endpoint string) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.faults {
+		if f.Endpoint != "" && f.Endpoint != endpoint {
+			continue
+		}
+
+		if s.faults[i].Times--; s.faults[i].Times == 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+		return f, true
+	}
+
+	return Fault{}, false
+}
+
+// Records each request along with the status it was answered with.
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		status := sw.status
+		if status == 0 && r.Context().Err() == nil {
+			status = http.StatusOK
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.requests = append(s.requests, Request{
+			Endpoint:  endpointOf(r),
+			RequestID: r.Header.Get("X-Request-ID"),
+			Status:    status,
+		})
+	})
+}
+
+// Notes the status code of the response, which is 0 until one is written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// not JSON, even if it starts out looking like it
+const malformed = `{"error": {"message": `
+
+// Responds with a ResponseError, as the Panobi API does.
+func writeError(w http.ResponseWriter, status int, message string) {
+	var re struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	re.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&re)
+}
+
+// Returns the endpoint a request was sent to, without the workspace and
+// external IDs.
+func endpointOf(r *http.Request) string {
+	return path.Dir(path.Dir(path.Clean(r.URL.Path)))
+}
+
+// Makes up a key in the same format as a real one.
+func newKey() panobi.KeyInfo {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("panobitest: %v", err))
+	}
+
+	return panobi.NewKeyInfo(newID(), newID(), []byte(hex.EncodeToString(secret)))
+}
+
+const idAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func newID() string {
+	var b strings.Builder
+	for i := 0; i < 22; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(idAlphabet))))
+		if err != nil {
+			panic(fmt.Sprintf("panobitest: %v", err))
+		}
+		b.WriteByte(idAlphabet[n.Int64()])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,280 @@
+package panobitest
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	panobi "github.com/panobi/metrics-sdk"
+)
+
+var day = civil.Date{Year: 2023, Month: 1, Day: 1}
+
+func Test_Server_Timeseries(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	client := srv.Client()
+	defer client.Close()
+
+	if err := client.SendMetricItems("metric", []panobi.MetricItem{
+		{Date: day, Value: 1},
+		{Date: day.AddDays(1), Value: 2},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// dates already stored are left alone, and new ones are added
+	if err := client.SendMetricItems("metric", []panobi.MetricItem{
+		{Date: day.AddDays(1), Value: 20},
+		{Date: day.AddDays(2), Value: 30},
+		{Date: day.AddDays(2), Value: 300},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []panobi.MetricItem{
+		{Date: day, Value: 1},
+		{Date: day.AddDays(1), Value: 2},
+		{Date: day.AddDays(2), Value: 30},
+	}
+	if got := srv.Timeseries("metric"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected items %v but got %v", want, got)
+	}
+
+	if err := client.DeleteMetricData("metric"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := srv.Timeseries("metric"); len(got) != 0 {
+		t.Errorf("expected no items after deleting but got %v", got)
+	}
+}
+
+func Test_Server_ChartData(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	client := srv.Client()
+	defer client.Close()
+
+	send := func(rows ...panobi.ChartData) {
+		if err := client.SendMetricChartData("metric", rows); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	send(panobi.ChartData{"label": "Foo", "value": 1})
+	send(panobi.ChartData{"label": "Bar", "value": 2})
+
+	want := []panobi.ChartData{
+		{"label": "Foo", "value": float64(1)},
+		{"label": "Bar", "value": float64(2)},
+	}
+	if got := srv.ChartData("metric"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected rows %v but got %v", want, got)
+	}
+
+	// replacing the rows means deleting them first
+	if err := client.DeleteMetricData("metric"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	send(panobi.ChartData{"label": "Baz", "value": 3})
+
+	want = []panobi.ChartData{{"label": "Baz", "value": float64(3)}}
+	if got := srv.ChartData("metric"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected rows %v but got %v", want, got)
+	}
+}
+
+func Test_Server_Verify(t *testing.T) {
+	srv := NewServer(WithVerifyOptions(panobi.WithRequiredSignatureVersion(panobi.SignatureV1)))
+	defer srv.Close()
+
+	other, _ := panobi.ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName   string
+		client     *panobi.Client
+		wantStatus int
+	}{
+		{
+			testName:   "success",
+			client:     srv.Client(panobi.WithSignatureVersion(panobi.SignatureV1)),
+			wantStatus: http.StatusOK,
+		},
+		{
+			testName:   "unknown key",
+			client:     panobi.CreateClient(other, panobi.WithBaseURL(srv.URL), panobi.WithSignatureVersion(panobi.SignatureV1)),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			testName:   "wrong signature version",
+			client:     srv.Client(),
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			defer tt.client.Close()
+			srv.Reset()
+
+			err := tt.client.SendMetricItem("metric", panobi.MetricItem{Date: day, Value: 1})
+
+			var apiErr *panobi.APIError
+			if tt.wantStatus == http.StatusOK && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if tt.wantStatus != http.StatusOK && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus) {
+				t.Fatalf("expected status %d but got `%v`", tt.wantStatus, err)
+			}
+
+			stored := len(srv.Timeseries("metric"))
+			if tt.wantStatus == http.StatusOK && stored != 1 || tt.wantStatus != http.StatusOK && stored != 0 {
+				t.Errorf("expected the item to be stored only if accepted, but %d were", stored)
+			}
+		})
+	}
+}
+
+func Test_Server_MalformedRequest(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	ki := srv.Key()
+	tests := []struct {
+		testName    string
+		uri         string
+		body        string
+		wantMessage string
+	}{
+		{
+			testName:    "not JSON",
+			uri:         string(panobi.TimeseriesURI),
+			body:        "{",
+			wantMessage: "malformed body",
+		},
+		{
+			testName:    "missing metric ID",
+			uri:         string(panobi.ChartDataURI),
+			body:        `{"items":[]}`,
+			wantMessage: "metricID is required",
+		},
+		{
+			testName:    "missing items",
+			uri:         string(panobi.TimeseriesURI),
+			body:        `{"metricID":"metric"}`,
+			wantMessage: "items are required",
+		},
+		{
+			testName:    "bad date",
+			uri:         string(panobi.TimeseriesURI),
+			body:        `{"metricID":"metric","items":[{"date":"yesterday","value":1}]}`,
+			wantMessage: "item 0:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", srv.URL+tt.uri+"/"+ki.WorkspaceID+"/"+ki.ExternalID, strings.NewReader(tt.body))
+			if err := panobi.SignRequest(req, ki); err != nil {
+				t.Fatalf("unexpected error signing: %v", err)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var b bytes.Buffer
+			b.ReadFrom(resp.Body)
+			if resp.StatusCode != http.StatusBadRequest || !strings.Contains(b.String(), tt.wantMessage) {
+				t.Errorf("expected status 400 with `%s` but got %d with `%s`", tt.wantMessage, resp.StatusCode, b.String())
+			}
+		})
+	}
+}
+
+func Test_Server_Inject(t *testing.T) {
+	tests := []struct {
+		testName     string
+		faults       []Fault
+		opts         []panobi.Option
+		wantStatuses []int
+		wantErr      int // status code of the APIError, or -1 for any other error
+	}{
+		{
+			testName:     "rate limited",
+			faults:       []Fault{{Status: http.StatusTooManyRequests}},
+			wantStatuses: []int{429, 200},
+		},
+		{
+			testName:     "server errors",
+			faults:       []Fault{{Status: http.StatusServiceUnavailable, Times: 2}},
+			wantStatuses: []int{503, 503, 200},
+		},
+		{
+			testName:     "malformed",
+			faults:       []Fault{{Status: http.StatusBadRequest, Malformed: true}},
+			wantStatuses: []int{400},
+			wantErr:      http.StatusBadRequest,
+		},
+		{
+			testName:     "other endpoint",
+			faults:       []Fault{{Endpoint: string(panobi.ChartDataURI), Status: http.StatusInternalServerError}},
+			wantStatuses: []int{200},
+		},
+		{
+			testName:     "latency",
+			faults:       []Fault{{Latency: time.Second}},
+			opts:         []panobi.Option{panobi.WithTimeout(100 * time.Millisecond), panobi.WithRetryPolicy(panobi.RetryPolicy{Attempts: 1})},
+			wantStatuses: []int{0},
+			wantErr:      -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			srv := NewServer()
+			defer srv.Close()
+
+			opts := append([]panobi.Option{panobi.WithRetryPolicy(panobi.RetryPolicy{Attempts: 3, BackoffInitial: time.Millisecond})}, tt.opts...)
+			client := srv.Client(opts...)
+			defer client.Close()
+
+			srv.Inject(tt.faults...)
+			err := client.SendMetricItem("metric", panobi.MetricItem{Date: day, Value: 1})
+
+			var apiErr *panobi.APIError
+			switch {
+			case tt.wantErr == 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr > 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantErr):
+				t.Fatalf("expected status %d but got `%v`", tt.wantErr, err)
+			case tt.wantErr < 0 && err == nil:
+				t.Fatalf("expected an error")
+			}
+
+			// the handler may still be finishing up once the client gives
+			// up
+			var statuses []int
+			for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+				statuses = statuses[:0]
+				for _, r := range srv.Requests() {
+					statuses = append(statuses, r.Status)
+				}
+				if len(statuses) == len(tt.wantStatuses) {
+					break
+				}
+			}
+			if !reflect.DeepEqual(statuses, tt.wantStatuses) {
+				t.Errorf("expected statuses %v but got %v", tt.wantStatuses, statuses)
+			}
+		})
+	}
+}
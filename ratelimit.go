@@ -0,0 +1,95 @@
+package panobi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Token bucket shared by all requests made through a transport. Besides
+// limiting the request rate, it holds back every request while the server
+// has asked us to back off, so that concurrent senders do not all retry at
+// once.
+type limiter struct {
+	mu          sync.Mutex
+	rate        float64 // tokens added per second; zero means no limit
+	burst       float64 // maximum number of tokens
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l := &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	l.last = l.now()
+
+	return l
+}
+
+// Waits until a request may be sent, or the context is done.
+func (l *limiter) wait(ctx context.Context) error {
+	for {
+		d := l.reserve()
+		if d <= 0 {
+			return nil
+		}
+
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// Takes a token if one is available, otherwise returns how long to wait
+// before trying again.
+func (l *limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if d := l.pausedUntil.Sub(now); d > 0 {
+		return d
+	}
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Holds back all requests for at least the given duration. Any saved up
+// burst is discarded, so that requests resume at the steady rate.
+func (l *limiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if until := now.Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+
+	l.tokens = 0
+	l.last = l.pausedUntil
+}
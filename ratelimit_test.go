@@ -0,0 +1,64 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_limiter(t *testing.T) {
+	now := time.UnixMilli(1672552800000)
+	l := newLimiter(10, 2)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	steps := []struct {
+		testName string
+		advance  time.Duration
+		pause    time.Duration
+		want     time.Duration
+	}{
+		{testName: "first of burst", want: 0},
+		{testName: "second of burst", want: 0},
+		{testName: "burst used up", want: 100 * time.Millisecond},
+		{testName: "refilled", advance: 100 * time.Millisecond, want: 0},
+		{testName: "paused", advance: time.Second, pause: time.Second, want: time.Second},
+		{testName: "pause over, burst discarded", advance: time.Second, want: 100 * time.Millisecond},
+		{testName: "steady rate", advance: 100 * time.Millisecond, want: 0},
+	}
+
+	for _, s := range steps {
+		now = now.Add(s.advance)
+		if s.pause > 0 {
+			l.pause(s.pause)
+		}
+		if got := l.reserve(); got != s.want {
+			t.Errorf("%s: expected wait of %s but got %s", s.testName, s.want, got)
+		}
+	}
+}
+
+func Test_limiter_SharedPause(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	tr := createTransport(ki, testOptions(srv.URL, WithRetryPolicy(RetryPolicy{Attempts: 1})))
+	if _, err := tr.post(context.Background(), &request{uri: TimeseriesURI, body: []byte("{}")}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected err to match `%v` but got `%v`", ErrRateLimited, err)
+	}
+
+	// another request on the same transport now waits for the pause
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tr.post(ctx, &request{uri: TimeseriesURI, body: []byte("{}")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected err to match `%v` but got `%v`", context.DeadlineExceeded, err)
+	}
+}
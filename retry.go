@@ -0,0 +1,120 @@
+package panobi
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	attempts          int           = 3
+	backoffInitial    time.Duration = 1 * time.Second
+	backoffMultiplier float64       = 2
+	backoffMax        time.Duration = 30 * time.Second
+	retryMaxElapsed   time.Duration = 2 * time.Minute
+)
+
+// Controls how requests are retried after a retryable failure. Requests are
+// retried on 408, 429 and 5xx responses (except 501), and on network errors
+// that are likely to be transient, such as timeouts and connection resets.
+//
+// Waits between attempts use exponential backoff with full jitter, unless
+// the server sends a Retry-After header, which is honoured as is.
+type RetryPolicy struct {
+	Attempts          int           // total attempts, including the first
+	BackoffInitial    time.Duration // upper bound of the wait before the first retry
+	BackoffMultiplier float64       // growth of the upper bound after each retry
+	MaxBackoff        time.Duration // cap on the upper bound; zero means no cap
+	MaxElapsed        time.Duration // give up rather than wait past this; zero means no limit
+}
+
+// Returns the retry policy used unless WithRetryPolicy is given.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Attempts:          attempts,
+		BackoffInitial:    backoffInitial,
+		BackoffMultiplier: backoffMultiplier,
+		MaxBackoff:        backoffMax,
+		MaxElapsed:        retryMaxElapsed,
+	}
+}
+
+// Returns the upper bound of the wait before the given retry, starting at 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.BackoffInitial)
+	for i := 1; i < retry; i++ {
+		d *= p.BackoffMultiplier
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+
+	return time.Duration(d)
+}
+
+// Picks a random wait between zero and the given upper bound.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests ||
+		code >= 500 && code != http.StatusNotImplemented
+}
+
+func isRetryableError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	var oe *net.OpError
+	return errors.As(err, &oe) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// Returns how long the server asked us to wait, either as a number of
+// seconds or as an HTTP date, or the given default if it did not say.
+func getRetryAfter(resp *http.Response, defaultRetryAfter time.Duration) time.Duration {
+	headerVal := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if headerVal == "" {
+		return defaultRetryAfter
+	}
+
+	retryAfter, err := strconv.ParseInt(headerVal, 10, 64)
+	if err == nil {
+		if retryAfter < 0 {
+			return defaultRetryAfter
+		}
+		return time.Duration(retryAfter) * time.Second
+	}
+
+	date, err := http.ParseTime(headerVal)
+	if err != nil {
+		return defaultRetryAfter
+	}
+
+	if d := time.Until(date); d > 0 {
+		return d
+	}
+
+	return 0
+}
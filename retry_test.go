@@ -0,0 +1,98 @@
+package panobi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_getRetryAfter(t *testing.T) {
+	tests := []struct {
+		testName string
+		header   string
+		want     time.Duration
+		wantMin  time.Duration
+	}{
+		{
+			testName: "missing",
+			header:   "",
+			want:     5 * time.Second,
+		},
+		{
+			testName: "seconds",
+			header:   "3",
+			want:     3 * time.Second,
+		},
+		{
+			testName: "negative",
+			header:   "-3",
+			want:     5 * time.Second,
+		},
+		{
+			testName: "garbage",
+			header:   "soon",
+			want:     5 * time.Second,
+		},
+		{
+			testName: "past date",
+			header:   "Sun, 01 Jan 2023 06:00:00 GMT",
+			want:     0,
+		},
+		{
+			testName: "future date",
+			header:   time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+			wantMin:  58 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+
+			got := getRetryAfter(resp, 5*time.Second)
+			if tt.wantMin > 0 {
+				if got < tt.wantMin || got > time.Hour {
+					t.Errorf("expected retry after close to an hour but got %s", got)
+				}
+			} else if got != tt.want {
+				t.Errorf("expected retry after to be %s but got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_RetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{
+		BackoffInitial:    time.Second,
+		BackoffMultiplier: 2,
+		MaxBackoff:        5 * time.Second,
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("expected backoff for retry %d to be %s but got %s", i+1, w, got)
+		}
+	}
+}
+
+func Test_isRetryableStatus(t *testing.T) {
+	for code, want := range map[int]bool{
+		200: false,
+		400: false,
+		401: false,
+		408: true,
+		429: true,
+		500: true,
+		501: false,
+		502: true,
+		503: true,
+	} {
+		if got := isRetryableStatus(code); got != want {
+			t.Errorf("expected retryable for %d to be %t but got %t", code, want, got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package panobi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Wraps an http.RoundTripper with extra behaviour, such as logging,
+// authenticating to a proxy or adding headers.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// An http.RoundTripper that signs requests to the Panobi API before passing
+// them on, so that requests built by your own HTTP stack are accepted. The
+// request it is given is not modified.
+type SigningRoundTripper struct {
+	Key  KeyInfo
+	Keys KeyProvider       // if set, asked for the key for each request instead of using Key
+	Next http.RoundTripper // http.DefaultTransport if nil
+
+	// SignatureV0 if empty
+	Version SignatureVersion
+
+	now func() time.Time
+}
+
+type signingKeyKey struct{}
+
+// Returns a context that carries the key to sign requests with, which takes
+// precedence over the key a SigningRoundTripper was given. The client uses
+// it to sign every attempt at a request with the same key.
+func withSigningKey(ctx context.Context, ki KeyInfo) context.Context {
+	return context.WithValue(ctx, signingKeyKey{}, ki)
+}
+
+func signingKey(ctx context.Context) KeyInfo {
+	ki, _ := ctx.Value(signingKeyKey{}).(KeyInfo)
+	return ki
+}
+
+type lastSignedKey struct{}
+
+// Returns a context under which a SigningRoundTripper records when it signed
+// last, and never signs twice with the same timestamp. The client uses it so
+// that a retry signed within the same millisecond as the attempt before it
+// is not identical to it, and so is not taken for a replay.
+func withLastSigned(ctx context.Context, last *time.Time) context.Context {
+	return context.WithValue(ctx, lastSignedKey{}, last)
+}
+
+// Returns the time to sign at, moved past the last signature's timestamp if
+// it would otherwise repeat it. A clock corrected for skew may step back
+// much further than that, which is left alone.
+func signingTime(ctx context.Context, now time.Time) time.Time {
+	last, ok := ctx.Value(lastSignedKey{}).(*time.Time)
+	if !ok {
+		return now
+	}
+
+	if ms := last.UnixMilli(); now.UnixMilli() <= ms && last.Sub(now) < time.Second {
+		now = time.UnixMilli(ms + 1)
+	}
+	*last = now
+
+	return now
+}
+
+// Creates a round tripper that signs requests with the given key before
+// passing them to next.
+func NewSigningRoundTripper(ki KeyInfo, next http.RoundTripper) *SigningRoundTripper {
+	return &SigningRoundTripper{Key: ki, Next: next}
+}
+
+func (rt *SigningRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	now := time.Now
+	if rt.now != nil {
+		now = rt.now
+	}
+
+	ki, ok := req.Context().Value(signingKeyKey{}).(KeyInfo)
+	if !ok {
+		ki = rt.Key
+		if rt.Keys != nil {
+			var err error
+			if ki, err = rt.Keys.Key(req.Context()); err != nil {
+				closeBody(req)
+				return nil, fmt.Errorf("signing key: %w", err)
+			}
+			// a provider may destroy the key it handed out once it rotates,
+			// so the request is signed with a copy of its own
+			ki = ki.clone()
+			defer ki.Destroy()
+		}
+	}
+
+	req = req.Clone(req.Context())
+	if err := signRequest(req, ki, rt.Version, signingTime(req.Context(), now())); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	next := rt.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return next.RoundTrip(req)
+}
+
+// Signs the request for the Panobi API with a v0 signature, setting the
+// X-Panobi-Signature and X-Panobi-Request-Timestamp headers, and
+// X-Request-ID if it is not already set. The body is read and replaced, so
+// the request can still be sent.
+func SignRequest(req *http.Request, ki KeyInfo) error {
+	return signRequest(req, ki, SignatureV0, time.Now())
+}
+
+// Like SignRequest, but with the given version of signature. The request
+// must not be changed after it is signed with v1, other than its body
+// being compressed.
+func SignRequestVersion(req *http.Request, ki KeyInfo, version SignatureVersion) error {
+	return signRequest(req, ki, version, time.Now())
+}
+
+func signRequest(req *http.Request, ki KeyInfo, version SignatureVersion, now time.Time) error {
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if req.Header.Get("X-Request-ID") == "" {
+		req.Header.Set("X-Request-ID", uuid.NewString())
+	}
+
+	var s *Signer
+	switch version {
+	case "", SignatureV0:
+		s = NewSigner(ki, &now)
+	case SignatureV1:
+		s = NewSignerV1(ki, CanonicalRequest{
+			Method:    req.Method,
+			Path:      req.URL.EscapedPath(),
+			RequestID: req.Header.Get("X-Request-ID"),
+		}, &now)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedSignature, version)
+	}
+
+	if err := copyBody(s, req); err != nil {
+		return err
+	}
+
+	si := s.Sum()
+	req.Header.Set("X-Panobi-Signature", si.S)
+	req.Header.Set("X-Panobi-Request-Timestamp", si.TS)
+
+	return nil
+}
+
+// A round tripper must close the request body, even on errors.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// Reads the whole request body and replaces it with a copy.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	setBody(req, b)
+
+	return b, nil
+}
+
+// Writes the request body to w, leaving the request able to be sent. The
+// body is read through GetBody if the request has it, so that a body held
+// in memory is neither consumed nor copied.
+func copyBody(w io.Writer, req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	if req.GetBody == nil {
+		b, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func setBody(req *http.Request, b []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.ContentLength = int64(len(b))
+}
+
+// Compresses request bodies with gzip before passing them on.
+func compressRequests(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var buf bytes.Buffer
+		err := gzipTo(&buf, func(w io.Writer) error {
+			return copyBody(w, req)
+		})
+		closeBody(req)
+		if err != nil {
+			return nil, err
+		}
+
+		setBody(req, buf.Bytes())
+		req.Header.Set("Content-Encoding", "gzip")
+
+		return next.RoundTrip(req)
+	})
+}
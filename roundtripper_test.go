@@ -0,0 +1,127 @@
+package panobi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SignRequest(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	now := time.Unix(1700000000, 0)
+	want, _ := CalculateSignature([]byte(`{"metricID":"metric"}`), ki, &now)
+
+	tests := []struct {
+		testName  string
+		body      string
+		requestID string
+		wantErr   string
+	}{
+		{
+			testName: "random request ID",
+			body:     `{"metricID":"metric"}`,
+		},
+		{
+			testName:  "request ID kept",
+			body:      `{"metricID":"metric"}`,
+			requestID: "my-id",
+		},
+		{
+			testName: "too large",
+			body:     strings.Repeat("x", maxInputBytes+1),
+			wantErr:  "input cannot be larger than 1048576 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "https://example.com", strings.NewReader(tt.body))
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+
+			err := signRequest(req, ki, SignatureV0, now)
+			if !errorIs(tt.wantErr, err) {
+				t.Fatalf("expected err to be `%s` but got `%v`", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := req.Header.Get("X-Panobi-Signature"); got != want.S {
+				t.Errorf("expected signature `%s` but got `%s`", want.S, got)
+			}
+			if got := req.Header.Get("X-Panobi-Request-Timestamp"); got != want.TS {
+				t.Errorf("expected timestamp `%s` but got `%s`", want.TS, got)
+			}
+			if got := req.Header.Get("X-Request-ID"); got == "" || tt.requestID != "" && got != tt.requestID {
+				t.Errorf("expected request ID `%s` but got `%s`", tt.requestID, got)
+			}
+			if b, _ := io.ReadAll(req.Body); string(b) != tt.body {
+				t.Errorf("expected the body to be restored but got `%s`", b)
+			}
+		})
+	}
+}
+
+func Test_SigningRoundTripper(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var got *http.Request
+	rt := NewSigningRoundTripper(ki, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		got = r
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com", strings.NewReader("{}"))
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Header.Get("X-Panobi-Signature") == "" {
+		t.Error("expected the request passed on to be signed")
+	}
+	if req.Header.Get("X-Panobi-Signature") != "" {
+		t.Error("expected the original request to be left alone")
+	}
+}
+
+func Test_Client_Middleware(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Order"); got != "first,second" {
+			t.Errorf("expected middleware to run in order but got `%s`", got)
+		}
+		if r.Header.Get("X-Panobi-Signature") == "" {
+			t.Error("expected the request to be signed")
+		}
+	}))
+	defer srv.Close()
+
+	mark := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get("X-Panobi-Signature") != "" {
+					t.Errorf("expected middleware %s to see the request before signing", name)
+				}
+				order := name
+				if prev := r.Header.Get("X-Order"); prev != "" {
+					order = prev + "," + name
+				}
+				r.Header.Set("X-Order", order)
+				return next.RoundTrip(r)
+			})
+		}
+	}
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithMiddleware(mark("first")), WithMiddleware(mark("second")))
+	defer client.Close()
+
+	if err := client.DeleteMetricData("metric"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -4,14 +4,57 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
 	maxInputBytes int = 1_048_576
+
+	// how far a request timestamp may be from the current time, unless
+	// another tolerance is given to VerifySignature
+	DefaultSignatureTolerance time.Duration = 5 * time.Minute
+)
+
+var (
+	// the signature header is missing or not of the form v0=<hex> or
+	// v1=<hex>
+	ErrMalformedSignature = errors.New("malformed signature")
+	// the signature uses a scheme that is unknown or not accepted
+	ErrUnsupportedSignature = errors.New("unsupported signature version")
+	// the timestamp header is missing or not in unix milliseconds
+	ErrMalformedTimestamp = errors.New("malformed timestamp")
+	// the timestamp is further in the past than the tolerance allows
+	ErrStaleTimestamp = errors.New("stale timestamp")
+	// the timestamp is further in the future than the tolerance allows
+	ErrFutureTimestamp = errors.New("timestamp in the future")
+	// the signature does not match the payload
+	ErrSignatureMismatch = errors.New("signature mismatch")
 )
 
+// Selects how requests are signed.
+type SignatureVersion string
+
+const (
+	// signs the timestamp and the body
+	SignatureV0 SignatureVersion = "v0"
+	// signs the timestamp, method, path, request ID and a hash of the body,
+	// so a signed body cannot be replayed against another endpoint
+	SignatureV1 SignatureVersion = "v1"
+)
+
+// The parts of a request, besides its body, that a v1 signature covers.
+type CanonicalRequest struct {
+	Method    string // such as POST
+	Path      string // escaped path, including the workspace and external IDs
+	RequestID string // X-Request-ID
+}
+
 // Holds information about a signature.
 type SignatureInfo struct {
 	S  string // the signature itself, calculated from a payload
@@ -22,30 +65,208 @@ type SignatureInfo struct {
 // information. The events endpoint requires that you include the calculated
 // signature and timestamp when making requests.
 func CalculateSignature(b []byte, ki KeyInfo, now *time.Time) (SignatureInfo, error) {
-	if len(b) > maxInputBytes {
-		return SignatureInfo{}, fmt.Errorf(errMaxNumberSize, "input", maxInputBytes, "bytes")
+	return calculate(NewSigner(ki, now), b)
+}
+
+// Calculates a v1 signature for the given byte payload and the request it
+// is sent with, using the given key information.
+func CalculateSignatureV1(b []byte, cr CanonicalRequest, ki KeyInfo, now *time.Time) (SignatureInfo, error) {
+	return calculate(NewSignerV1(ki, cr, now), b)
+}
+
+func calculate(s *Signer, b []byte) (SignatureInfo, error) {
+	if _, err := s.Write(b); err != nil {
+		return SignatureInfo{}, err
 	}
 
-	var ts string
-	if now != nil {
-		ts = fmt.Sprint(now.UnixMilli())
-	} else {
-		ts = fmt.Sprint(time.Now().UnixMilli())
+	return s.Sum(), nil
+}
+
+// Calculates a signature from a payload written to it in pieces, so that
+// the payload is never copied or held in memory as a whole. Use io.Copy to
+// sign a stream.
+type Signer struct {
+	version SignatureVersion
+	ts      string
+	mac     hash.Hash
+	body    hash.Hash // v1 only
+	n       int
+	sig     []byte
+}
 
+// Creates a signer for a v0 signature, whose timestamp is taken now.
+func NewSigner(ki KeyInfo, now *time.Time) *Signer {
+	return newSigner(ki, SignatureV0, CanonicalRequest{}, timestamp(now))
+}
+
+// Creates a signer for a v1 signature of the given request, whose timestamp
+// is taken now.
+func NewSignerV1(ki KeyInfo, cr CanonicalRequest, now *time.Time) *Signer {
+	return newSigner(ki, SignatureV1, cr, timestamp(now))
+}
+
+func newSigner(ki KeyInfo, version SignatureVersion, cr CanonicalRequest, ts string) *Signer {
+	s := &Signer{
+		version: version,
+		ts:      ts,
+		mac:     hmac.New(sha256.New, ki.Reveal()),
+	}
+
+	// everything but the payload, or its hash, is written up front, so the
+	// key is not needed again
+	switch version {
+	case SignatureV1:
+		s.body = sha256.New()
+		fmt.Fprintf(s.mac, "%s\n%s\n%s\n%s\n%s\n", version, ts, cr.Method, cr.Path, cr.RequestID)
+	default:
+		fmt.Fprintf(s.mac, "%s:%s:", version, ts)
+	}
+
+	return s
+}
+
+// Adds the next part of the payload to the signature. Fails once the
+// payload is larger than the API accepts.
+func (s *Signer) Write(p []byte) (int, error) {
+	if s.n += len(p); s.n > maxInputBytes {
+		return 0, newLimitError(ErrPayloadTooLarge, "input", maxInputBytes, "bytes")
 	}
 
-	message := fmt.Sprintf("%s:%s:%s", "v0", ts, b)
-	mac := hmac.New(sha256.New, []byte(ki.K))
-	mac.Write([]byte(message))
-	signature := "v0=" + string(hex.EncodeToString(mac.Sum(nil)))
+	if s.body != nil {
+		return s.body.Write(p)
+	}
+	return s.mac.Write(p)
+}
 
+// Returns the signature of the payload. Nothing more may be written once
+// it has been called.
+func (s *Signer) Sum() SignatureInfo {
 	return SignatureInfo{
-		S:  signature,
-		TS: ts,
-	}, nil
+		S:  string(s.version) + "=" + hex.EncodeToString(s.sum()),
+		TS: s.ts,
+	}
+}
+
+func (s *Signer) sum() []byte {
+	if s.sig == nil {
+		if s.body != nil {
+			var sum [sha256.Size]byte
+			fmt.Fprintf(s.mac, "%x", s.body.Sum(sum[:0]))
+		}
+		s.sig = s.mac.Sum(nil)
+	}
+
+	return s.sig
+}
+
+func timestamp(now *time.Time) string {
+	if now != nil {
+		return fmt.Sprint(now.UnixMilli())
+	}
+
+	return fmt.Sprint(time.Now().UnixMilli())
+}
+
+// Verifies the v0 signature and timestamp sent with a request against its
+// payload, the same way the Panobi API does. Timestamps further than the
+// tolerance from the current time are rejected; zero means
+// DefaultSignatureTolerance. Use errors.Is with the errors above to check
+// why verification failed. A v1 signature needs the request it was sent
+// with; use VerifyRequestSignature for those.
+func VerifySignature(b []byte, signature, timestamp string, ki KeyInfo, tolerance time.Duration) error {
+	return verifySignature(b, nil, signature, timestamp, ki, tolerance, time.Now())
+}
+
+// Like VerifySignature, but takes the signature headers from the request,
+// and accepts both v0 and v1 signatures. The body is given separately, as
+// it has usually been read already.
+func VerifyRequestSignature(r *http.Request, b []byte, ki KeyInfo, tolerance time.Duration) error {
+	return verifyRequestSignature(r, b, ki, tolerance, time.Now())
+}
+
+func verifyRequestSignature(r *http.Request, b []byte, ki KeyInfo, tolerance time.Duration, now time.Time) error {
+	cr := CanonicalRequest{
+		Method:    r.Method,
+		Path:      r.URL.EscapedPath(),
+		RequestID: r.Header.Get("X-Request-ID"),
+	}
+
+	return verifySignature(b, &cr,
+		r.Header.Get("X-Panobi-Signature"),
+		r.Header.Get("X-Panobi-Request-Timestamp"),
+		ki, tolerance, now)
+}
+
+// Verifies a signature of either version; cr is nil if only v0 signatures
+// can be checked.
+func verifySignature(b []byte, cr *CanonicalRequest, signature, timestamp string, ki KeyInfo, tolerance time.Duration, now time.Time) error {
+	version, digest, ok := strings.Cut(signature, "=")
+	if !ok {
+		return ErrMalformedSignature
+	}
+	switch SignatureVersion(version) {
+	case SignatureV0:
+	case SignatureV1:
+		if cr == nil {
+			return fmt.Errorf("%w: %q needs the request", ErrUnsupportedSignature, version)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedSignature, version)
+	}
+	want, err := hex.DecodeString(digest)
+	if err != nil || len(want) != sha256.Size {
+		return ErrMalformedSignature
+	}
+
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMalformedTimestamp
+	}
+
+	if tolerance == 0 {
+		tolerance = DefaultSignatureTolerance
+	}
+	if skew := now.Sub(time.UnixMilli(ms)); skew > tolerance {
+		return fmt.Errorf("%w: %s old", ErrStaleTimestamp, skew.Round(time.Millisecond))
+	} else if skew < -tolerance {
+		return fmt.Errorf("%w: %s ahead", ErrFutureTimestamp, (-skew).Round(time.Millisecond))
+	}
+
+	if len(b) > maxInputBytes {
+		return newLimitError(ErrPayloadTooLarge, "input", maxInputBytes, "bytes")
+	}
+
+	got := sign(b, ki, timestamp)
+	if cr != nil && version == string(SignatureV1) {
+		got = signV1(b, *cr, ki, timestamp)
+	}
+	if !hmac.Equal(got, want) {
+		return ErrSignatureMismatch
+	}
+
+	return nil
+}
+
+// Returns the v0 HMAC of the payload at the given timestamp.
+func sign(b []byte, ki KeyInfo, ts string) []byte {
+	s := newSigner(ki, SignatureV0, CanonicalRequest{}, ts)
+	s.mac.Write(b)
+
+	return s.sum()
+}
+
+// Returns the v1 HMAC of the canonical request at the given timestamp:
+// the version, timestamp, method, path, request ID and hex SHA-256 of the
+// payload, one per line.
+func signV1(b []byte, cr CanonicalRequest, ki KeyInfo, ts string) []byte {
+	s := newSigner(ki, SignatureV1, cr, ts)
+	s.body.Write(b)
+
+	return s.sum()
 }
 
-// Test for equality against the given signature information.
+// Test for equality against the given signature information. This is not a
+// constant-time comparison; use VerifySignature to check a request.
 func (si SignatureInfo) Equals(other SignatureInfo) bool {
 	return si.S == other.S && si.TS == other.TS
 }
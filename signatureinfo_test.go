@@ -1,7 +1,12 @@
 package panobi
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -38,3 +43,285 @@ func Test_CalculateSignature(t *testing.T) {
 		})
 	}
 }
+
+func Test_CalculateSignatureV1(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	now := time.UnixMilli(1672552800000)
+	cr := CanonicalRequest{
+		Method:    "POST",
+		Path:      string(TimeseriesURI) + "/1234567890123456789012/1234567890123456789012",
+		RequestID: "06e4f4cf-aa09-4e09-ad2b-e8608d540e3b",
+	}
+
+	tests := []struct {
+		testName          string
+		input             string
+		cr                CanonicalRequest
+		wantSignatureInfo SignatureInfo
+		wantErr           string
+	}{
+		{
+			testName: "success",
+			input:    "Hello, world!",
+			cr:       cr,
+			wantSignatureInfo: SignatureInfo{
+				S:  "v1=e1e95e12883ad7f1a301f44f21012c5e90df117193b6ece3f481a642e1296754",
+				TS: "1672552800000",
+			},
+		},
+		{
+			testName: "too large",
+			input:    strings.Repeat("x", maxInputBytes+1),
+			cr:       cr,
+			wantErr:  "input cannot be larger than 1048576 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, err := CalculateSignatureV1([]byte(tt.input), tt.cr, ki, &now)
+			if !got.Equals(tt.wantSignatureInfo) {
+				t.Errorf("expected signature info to be `%v` but got `%v`", tt.wantSignatureInfo, got)
+			}
+			if !errorIs(tt.wantErr, err) {
+				t.Errorf("expected err to be `%s` but got `%v`", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_verifyRequestSignature(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	now := time.UnixMilli(1672552800000)
+	ids := "/1234567890123456789012/1234567890123456789012"
+
+	tests := []struct {
+		testName  string
+		version   SignatureVersion
+		signedFor string
+		sentTo    string
+		wantErr   error
+	}{
+		{
+			testName:  "v0",
+			version:   SignatureV0,
+			signedFor: string(TimeseriesURI) + ids,
+			sentTo:    string(TimeseriesURI) + ids,
+		},
+		{
+			testName:  "v1",
+			version:   SignatureV1,
+			signedFor: string(TimeseriesURI) + ids,
+			sentTo:    string(TimeseriesURI) + ids,
+		},
+		{
+			testName:  "v0 replayed against another endpoint",
+			version:   SignatureV0,
+			signedFor: string(TimeseriesURI) + ids,
+			sentTo:    string(DeleteURI) + ids,
+		},
+		{
+			testName:  "v1 replayed against another endpoint",
+			version:   SignatureV1,
+			signedFor: string(TimeseriesURI) + ids,
+			sentTo:    string(DeleteURI) + ids,
+			wantErr:   ErrSignatureMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "https://example.com"+tt.signedFor, strings.NewReader("{}"))
+			if err := signRequest(req, ki, tt.version, now); err != nil {
+				t.Fatalf("unexpected error signing: %v", err)
+			}
+
+			req.URL.Path = tt.sentTo
+			err := verifyRequestSignature(req, []byte("{}"), ki, 0, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected err to match `%v` but got `%v`", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_Signer(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	now := time.UnixMilli(1672552800000)
+	cr := CanonicalRequest{
+		Method:    "POST",
+		Path:      string(TimeseriesURI) + "/1234567890123456789012/1234567890123456789012",
+		RequestID: "06e4f4cf-aa09-4e09-ad2b-e8608d540e3b",
+	}
+	input := strings.Repeat("Hello, world!", 100)
+
+	v0, _ := CalculateSignature([]byte(input), ki, &now)
+	v1, _ := CalculateSignatureV1([]byte(input), cr, ki, &now)
+
+	tests := []struct {
+		testName          string
+		signer            *Signer
+		input             string
+		wantSignatureInfo SignatureInfo
+		wantErr           string
+	}{
+		{
+			testName:          "v0",
+			signer:            NewSigner(ki, &now),
+			input:             input,
+			wantSignatureInfo: v0,
+		},
+		{
+			testName:          "v1",
+			signer:            NewSignerV1(ki, cr, &now),
+			input:             input,
+			wantSignatureInfo: v1,
+		},
+		{
+			testName: "too large",
+			signer:   NewSigner(ki, &now),
+			input:    strings.Repeat("x", maxInputBytes+1),
+			wantErr:  "input cannot be larger than 1048576 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			// written a byte at a time, to sign it in pieces
+			_, err := io.Copy(tt.signer, iotest.OneByteReader(strings.NewReader(tt.input)))
+			if !errorIs(tt.wantErr, err) {
+				t.Fatalf("expected err to be `%s` but got `%v`", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if si := tt.signer.Sum(); !si.Equals(tt.wantSignatureInfo) {
+				t.Errorf("expected signature info to be `%v` but got `%v`", tt.wantSignatureInfo, si)
+			}
+		})
+	}
+}
+
+func Test_verifySignature(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	other, _ := ParseKey("1234567890123456789012-1234567890123456789012-456")
+	now := time.UnixMilli(1672552800000)
+	signature := "v0=8a43a27d205a9d27801d110d1cd627712f2fbf3f123bb6506565917b563def78"
+
+	tests := []struct {
+		testName  string
+		input     string
+		signature string
+		timestamp string
+		ki        KeyInfo
+		now       time.Time
+		wantErr   error
+	}{
+		{
+			testName:  "success",
+			input:     "Hello, world!",
+			signature: signature,
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now,
+		},
+		{
+			testName:  "within tolerance",
+			input:     "Hello, world!",
+			signature: signature,
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now.Add(DefaultSignatureTolerance),
+		},
+		{
+			testName:  "body changed",
+			input:     "Hello, world?",
+			signature: signature,
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now,
+			wantErr:   ErrSignatureMismatch,
+		},
+		{
+			testName:  "wrong key",
+			input:     "Hello, world!",
+			signature: signature,
+			timestamp: "1672552800000",
+			ki:        other,
+			now:       now,
+			wantErr:   ErrSignatureMismatch,
+		},
+		{
+			testName:  "timestamp changed",
+			input:     "Hello, world!",
+			signature: signature,
+			timestamp: "1672552800001",
+			ki:        ki,
+			now:       now,
+			wantErr:   ErrSignatureMismatch,
+		},
+		{
+			testName:  "stale",
+			input:     "Hello, world!",
+			signature: signature,
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now.Add(DefaultSignatureTolerance + time.Millisecond),
+			wantErr:   ErrStaleTimestamp,
+		},
+		{
+			testName:  "future",
+			input:     "Hello, world!",
+			signature: signature,
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now.Add(-DefaultSignatureTolerance - time.Millisecond),
+			wantErr:   ErrFutureTimestamp,
+		},
+		{
+			testName:  "missing signature",
+			input:     "Hello, world!",
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now,
+			wantErr:   ErrMalformedSignature,
+		},
+		{
+			testName:  "not hex",
+			input:     "Hello, world!",
+			signature: "v0=xyz",
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now,
+			wantErr:   ErrMalformedSignature,
+		},
+		{
+			testName:  "other version",
+			input:     "Hello, world!",
+			signature: "v9=8a43a27d205a9d27801d110d1cd627712f2fbf3f123bb6506565917b563def78",
+			timestamp: "1672552800000",
+			ki:        ki,
+			now:       now,
+			wantErr:   ErrUnsupportedSignature,
+		},
+		{
+			testName:  "malformed timestamp",
+			input:     "Hello, world!",
+			signature: signature,
+			timestamp: "yesterday",
+			ki:        ki,
+			now:       now,
+			wantErr:   ErrMalformedTimestamp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := verifySignature([]byte(tt.input), nil, tt.signature, tt.timestamp, tt.ki, 0, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected err to match `%v` but got `%v`", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,519 @@
+package panobi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolSegmentExt      string        = ".seg"
+	spoolMaxBytes        int64         = 64 << 20
+	spoolSegmentBytes    int64         = 4 << 20
+	spoolDrainInterval   time.Duration = 30 * time.Second
+	spoolRecordHeaderLen int           = 9
+	spoolMaxFailures     int           = 3
+
+	recordEntry byte = 1
+	recordAck   byte = 2
+)
+
+var (
+	// returned when appending to a spool would take it past its size cap
+	ErrSpoolFull = errors.New("spool full")
+	// wrapped into the error of a request that failed, but is kept in the
+	// spool and will be sent again later
+	ErrSpooled = errors.New("request spooled for later delivery")
+)
+
+// Decides when a spool flushes its writes to stable storage.
+type SyncPolicy int
+
+const (
+	SyncAlways SyncPolicy = iota // after every write
+	SyncNever                    // leave it to the operating system
+)
+
+// Configures a Spool. Pass any number of options to OpenSpool.
+type SpoolOption func(*spoolOptions)
+
+type spoolOptions struct {
+	maxBytes      int64
+	segmentBytes  int64
+	sync          SyncPolicy
+	drainInterval time.Duration
+}
+
+// Caps the total size of the spool on disk. Defaults to 64 MiB.
+func WithSpoolMaxBytes(n int64) SpoolOption {
+	return func(o *spoolOptions) {
+		o.maxBytes = n
+	}
+}
+
+// Starts a new segment file once the current one reaches the given size.
+// Defaults to 4 MiB.
+func WithSegmentBytes(n int64) SpoolOption {
+	return func(o *spoolOptions) {
+		o.segmentBytes = n
+	}
+}
+
+// Decides when writes are flushed to stable storage. Defaults to
+// SyncAlways.
+func WithSyncPolicy(p SyncPolicy) SpoolOption {
+	return func(o *spoolOptions) {
+		o.sync = p
+	}
+}
+
+// Sets how often a client using the spool tries to send spooled requests
+// in the background. Defaults to 30 seconds; zero or less disables the
+// background drainer, leaving it to Client.DrainSpool.
+func WithDrainInterval(d time.Duration) SpoolOption {
+	return func(o *spoolOptions) {
+		o.drainInterval = d
+	}
+}
+
+// Write-ahead log of requests, kept in a directory. A client using a spool
+// appends each request before sending it, and removes it once it has been
+// accepted or its contents permanently rejected. Requests that could not be
+// sent, for example because Panobi was unreachable or the key was rejected,
+// stay in the spool and are sent again in order, either by a background
+// drainer or by Client.DrainSpool, including after the process restarts.
+//
+// The spool is made up of segment files holding checksummed records. A
+// segment that was only partially written, for example because the process
+// crashed, is recovered up to the last intact record.
+type Spool struct {
+	dir string
+	o   spoolOptions
+
+	mu       sync.Mutex
+	segments []*segment // oldest first; the last one is being written
+	pending  []*spoolEntry
+	nextSeq  uint64
+	size     int64
+}
+
+type segment struct {
+	path    string
+	num     uint64
+	size    int64
+	unacked int
+	f       *os.File // only set for the segment being written
+}
+
+type spoolEntry struct {
+	seq      uint64
+	seg      *segment
+	req      *request
+	busy     bool // being sent right now
+	failures int  // drains in a row that failed to send it
+}
+
+// on-disk form of a spooled request
+type spoolRecord struct {
+	Seq       uint64 `json:"seq"`
+	URI       apiURI `json:"uri"`
+	MetricID  string `json:"metricID"`
+	Items     int    `json:"items"`
+	Body      []byte `json:"body"`
+	RequestID string `json:"requestID"`
+}
+
+// Opens the spool in the given directory, creating it if needed, and
+// recovers any requests left over from a previous run.
+func OpenSpool(dir string, opts ...SpoolOption) (*Spool, error) {
+	o := spoolOptions{
+		maxBytes:      spoolMaxBytes,
+		segmentBytes:  spoolSegmentBytes,
+		sync:          SyncAlways,
+		drainInterval: spoolDrainInterval,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, o: o}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	if err := s.rotate(); err != nil {
+		return nil, err
+	}
+	s.compact()
+
+	return s, nil
+}
+
+// Returns the number of requests waiting in the spool.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.pending)
+}
+
+// Closes the segment being written. Requests still in the spool are kept
+// for the next time it is opened.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := s.active()
+	if active == nil || active.f == nil {
+		return nil
+	}
+
+	err := active.f.Close()
+	active.f = nil
+
+	return err
+}
+
+// Reads all existing segments, collecting the requests that were never
+// acknowledged.
+func (s *Spool) recover() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+spoolSegmentExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	entries := make(map[uint64]*spoolEntry)
+	acked := make(map[uint64]bool)
+
+	for _, path := range paths {
+		num, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &segment{path: path, num: num}
+		if err := readSegment(seg, func(kind byte, payload []byte) {
+			switch kind {
+			case recordEntry:
+				var rec spoolRecord
+				if json.Unmarshal(payload, &rec) != nil {
+					return
+				}
+				entries[rec.Seq] = &spoolEntry{
+					seq: rec.Seq,
+					seg: seg,
+					req: &request{
+						uri:      rec.URI,
+						metricID: rec.MetricID,
+						items:    rec.Items,
+						body:     rec.Body,
+						id:       rec.RequestID,
+					},
+				}
+				if rec.Seq >= s.nextSeq {
+					s.nextSeq = rec.Seq + 1
+				}
+			case recordAck:
+				if len(payload) == 8 {
+					acked[binary.BigEndian.Uint64(payload)] = true
+				}
+			}
+		}); err != nil {
+			return err
+		}
+
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+	}
+
+	for seq, e := range entries {
+		if acked[seq] {
+			continue
+		}
+		e.seg.unacked++
+		s.pending = append(s.pending, e)
+	}
+	sort.Slice(s.pending, func(i, j int) bool {
+		return s.pending[i].seq < s.pending[j].seq
+	})
+
+	return nil
+}
+
+// Calls the given function for every intact record in the segment, stopping
+// quietly at the first record that is truncated or fails its checksum.
+func readSegment(seg *segment, f func(kind byte, payload []byte)) error {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	seg.size = info.Size()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, spoolRecordHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil
+		}
+
+		n := binary.BigEndian.Uint32(header[0:4])
+		if int64(n) > seg.size {
+			return nil
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[8:9])
+		crc.Write(payload)
+		if crc.Sum32() != binary.BigEndian.Uint32(header[4:8]) {
+			return nil
+		}
+
+		f(header[8], payload)
+	}
+}
+
+func encodeRecord(kind byte, payload []byte) []byte {
+	b := make([]byte, spoolRecordHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(payload)))
+	b[8] = kind
+	copy(b[spoolRecordHeaderLen:], payload)
+
+	crc := crc32.NewIEEE()
+	crc.Write(b[8:])
+	binary.BigEndian.PutUint32(b[4:8], crc.Sum32())
+
+	return b
+}
+
+// Writes the request to the spool before it is sent.
+func (s *Spool) append(req *request) (*spoolEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &spoolEntry{req: req, busy: true}
+	if err := s.writeEntry(e); err != nil {
+		return nil, err
+	}
+	s.pending = append(s.pending, e)
+
+	return e, nil
+}
+
+// Writes a record of the entry's request under the next sequence number,
+// and moves the entry to the segment it was written to.
+func (s *Spool) writeEntry(e *spoolEntry) error {
+	req := e.req
+	payload, err := json.Marshal(&spoolRecord{
+		Seq:       s.nextSeq,
+		URI:       req.uri,
+		MetricID:  req.metricID,
+		Items:     req.items,
+		Body:      req.body,
+		RequestID: req.id,
+	})
+	if err != nil {
+		return err
+	}
+
+	record := encodeRecord(recordEntry, payload)
+	if s.size+int64(len(record)) > s.o.maxBytes {
+		return ErrSpoolFull
+	}
+
+	if active := s.active(); active.size > 0 && active.size+int64(len(record)) > s.o.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.write(record); err != nil {
+		return err
+	}
+
+	e.seq, e.seg = s.nextSeq, s.active()
+	e.seg.unacked++
+	s.nextSeq++
+
+	return nil
+}
+
+// Removes the entry from the spool, once it no longer needs sending.
+func (s *Spool) ack(e *spoolEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(e)
+	err := s.writeAck(e)
+	s.compact()
+
+	return err
+}
+
+// Moves the entry to the back of the spool, so that the entries behind it
+// are sent first, and releases it. It is written out again and its old
+// record acknowledged, so that its old segment can be deleted once the
+// rest of it is sent. If the process stops in between, it is sent twice
+// with the same request ID.
+func (s *Spool) requeue(e *spoolEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.busy = false
+
+	old := *e
+	if err := s.writeEntry(e); err != nil {
+		return err
+	}
+	s.remove(e)
+	s.pending = append(s.pending, e)
+
+	err := s.writeAck(&old)
+	s.compact()
+
+	return err
+}
+
+func (s *Spool) remove(e *spoolEntry) {
+	for i, p := range s.pending {
+		if p == e {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// Acknowledges the entry's record, which no longer needs sending.
+func (s *Spool) writeAck(e *spoolEntry) error {
+	e.seg.unacked--
+
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, e.seq)
+
+	return s.write(encodeRecord(recordAck, payload))
+}
+
+// Marks the entry as no longer being sent, so that it may be drained.
+func (s *Spool) release(e *spoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.busy = false
+}
+
+// Claims the oldest entries that are not being sent right now.
+func (s *Spool) claim() []*spoolEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var claimed []*spoolEntry
+	for _, e := range s.pending {
+		if !e.busy {
+			e.busy = true
+			claimed = append(claimed, e)
+		}
+	}
+
+	return claimed
+}
+
+func (s *Spool) active() *segment {
+	if len(s.segments) == 0 {
+		return nil
+	}
+
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *Spool) write(record []byte) error {
+	active := s.active()
+	if active == nil || active.f == nil {
+		return errors.New("spool closed")
+	}
+
+	n, err := active.f.Write(record)
+	active.size += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if s.o.sync == SyncAlways {
+		return active.f.Sync()
+	}
+
+	return nil
+}
+
+// Starts a new segment for writing.
+func (s *Spool) rotate() error {
+	var num uint64
+	if active := s.active(); active != nil {
+		num = active.num + 1
+		if active.f != nil {
+			if err := active.f.Close(); err != nil {
+				return err
+			}
+			active.f = nil
+		}
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", num, spoolSegmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, &segment{path: path, num: num, f: f})
+
+	return nil
+}
+
+// Deletes segments that no longer hold anything that needs sending.
+// Segments are only ever deleted oldest first, so that an acknowledgement
+// written to a later segment cannot be lost while the entry it refers to
+// is still on disk.
+func (s *Spool) compact() {
+	for len(s.segments) > 1 && s.segments[0].unacked == 0 {
+		if err := os.Remove(s.segments[0].path); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		s.size -= s.segments[0].size
+		s.segments = s.segments[1:]
+	}
+
+	// with nothing pending, the segment being written holds only
+	// acknowledgements, so it can start over
+	if active := s.active(); len(s.pending) == 0 && active != nil && active.f != nil && active.size > 0 {
+		if err := active.f.Truncate(0); err == nil {
+			s.size -= active.size
+			active.size = 0
+		}
+	}
+}
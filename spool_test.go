@@ -0,0 +1,310 @@
+package panobi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Spool_Recover(t *testing.T) {
+	tests := []struct {
+		testName    string
+		ack         []int
+		corruptTail bool
+		wantBodies  []string
+	}{
+		{
+			testName:   "nothing acknowledged",
+			wantBodies: []string{"a", "b", "c"},
+		},
+		{
+			testName:   "some acknowledged",
+			ack:        []int{1},
+			wantBodies: []string{"a", "c"},
+		},
+		{
+			testName:   "all acknowledged",
+			ack:        []int{0, 1, 2},
+			wantBodies: nil,
+		},
+		{
+			testName:    "partially written segment",
+			corruptTail: true,
+			wantBodies:  []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+
+			s, err := OpenSpool(dir)
+			if err != nil {
+				t.Fatalf("unexpected error opening spool: %v", err)
+			}
+
+			var entries []*spoolEntry
+			for _, body := range []string{"a", "b", "c"} {
+				e, err := s.append(&request{uri: TimeseriesURI, metricID: "metric", body: []byte(body), id: "id-" + body})
+				if err != nil {
+					t.Fatalf("unexpected error appending: %v", err)
+				}
+				entries = append(entries, e)
+			}
+			for _, i := range tt.ack {
+				if err := s.ack(entries[i]); err != nil {
+					t.Fatalf("unexpected error acknowledging: %v", err)
+				}
+			}
+			s.Close()
+
+			if tt.corruptTail {
+				path := s.active().path
+				info, _ := os.Stat(path)
+				os.Truncate(path, info.Size()-3)
+			}
+
+			s, err = OpenSpool(dir)
+			if err != nil {
+				t.Fatalf("unexpected error reopening spool: %v", err)
+			}
+			defer s.Close()
+
+			if len(s.pending) != len(tt.wantBodies) {
+				t.Fatalf("expected %d pending request(s) but got %d", len(tt.wantBodies), len(s.pending))
+			}
+			for i, e := range s.pending {
+				if string(e.req.body) != tt.wantBodies[i] || e.req.id != "id-"+tt.wantBodies[i] {
+					t.Errorf("expected pending request %d to be `%s` but got `%s` with ID `%s`", i, tt.wantBodies[i], e.req.body, e.req.id)
+				}
+			}
+
+			if len(tt.wantBodies) == 0 {
+				paths, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+				if len(paths) != 1 {
+					t.Errorf("expected a single segment once everything is acknowledged but got %d", len(paths))
+				}
+			}
+		})
+	}
+}
+
+func Test_Spool_Full(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), WithSpoolMaxBytes(200), WithSegmentBytes(100))
+	if err != nil {
+		t.Fatalf("unexpected error opening spool: %v", err)
+	}
+	defer s.Close()
+
+	req := &request{uri: TimeseriesURI, metricID: "metric", body: []byte("{}")}
+	if _, err := s.append(req); err != nil {
+		t.Fatalf("unexpected error appending: %v", err)
+	}
+	if _, err := s.append(req); !errors.Is(err, ErrSpoolFull) {
+		t.Errorf("expected err to match `%v` but got `%v`", ErrSpoolFull, err)
+	}
+}
+
+func Test_Client_Spool(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var status int32 = http.StatusServiceUnavailable
+	var mu sync.Mutex
+	var ids []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		mu.Unlock()
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	s, err := OpenSpool(t.TempDir(), WithDrainInterval(0))
+	if err != nil {
+		t.Fatalf("unexpected error opening spool: %v", err)
+	}
+	defer s.Close()
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithSpool(s), WithRetryPolicy(RetryPolicy{Attempts: 1}))
+	defer client.Close()
+
+	// unavailable: the request is kept
+	if err := client.DeleteMetricData("metric"); !errors.Is(err, ErrSpooled) {
+		t.Errorf("expected err to match `%v` but got `%v`", ErrSpooled, err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("expected 1 spooled request but got %d", s.Len())
+	}
+
+	// still unavailable: draining stops and keeps the request
+	if err := client.DrainSpool(context.Background()); err == nil || s.Len() != 1 {
+		t.Errorf("expected drain to fail and keep the request but got `%v` with %d spooled", err, s.Len())
+	}
+
+	// available again: the request is sent with the same ID
+	atomic.StoreInt32(&status, http.StatusOK)
+	if err := client.DrainSpool(context.Background()); err != nil {
+		t.Errorf("unexpected error draining: %v", err)
+	}
+	if s.Len() != 0 {
+		t.Errorf("expected no spooled requests but got %d", s.Len())
+	}
+	if len(ids) != 3 || ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Errorf("expected the same request ID on every attempt but got %v", ids)
+	}
+
+	// rejected: the request is not kept
+	atomic.StoreInt32(&status, http.StatusBadRequest)
+	if err := client.DeleteMetricData("metric"); err == nil || errors.Is(err, ErrSpooled) {
+		t.Errorf("expected a rejection that is not spooled but got `%v`", err)
+	}
+	if s.Len() != 0 {
+		t.Errorf("expected no spooled requests but got %d", s.Len())
+	}
+
+	// unauthorized, as while a key is rotated: the request is kept until
+	// the key is accepted again
+	atomic.StoreInt32(&status, http.StatusUnauthorized)
+	if err := client.DeleteMetricData("metric"); !errors.Is(err, ErrSpooled) || !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected an unauthorized error that is spooled but got `%v`", err)
+	}
+	if err := client.DrainSpool(context.Background()); err == nil || s.Len() != 1 {
+		t.Errorf("expected drain to fail and keep the request but got `%v` with %d spooled", err, s.Len())
+	}
+	atomic.StoreInt32(&status, http.StatusOK)
+	if err := client.DrainSpool(context.Background()); err != nil || s.Len() != 0 {
+		t.Errorf("expected drain to send the request but got `%v` with %d spooled", err, s.Len())
+	}
+}
+
+func Test_Client_SpoolReplayOnStartup(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Request-ID")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	s, _ := OpenSpool(dir)
+	s.append(&request{uri: DeleteURI, metricID: "metric", body: []byte("{}"), id: "left-over"})
+	s.Close()
+
+	s, err := OpenSpool(dir, WithDrainInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error opening spool: %v", err)
+	}
+	defer s.Close()
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithSpool(s))
+	defer client.Close()
+
+	select {
+	case id := <-received:
+		if id != "left-over" {
+			t.Errorf("expected request ID `left-over` but got `%s`", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the spooled request to be replayed")
+	}
+}
+
+func Test_Client_SpoolFull(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var status int32 = http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	s, err := OpenSpool(t.TempDir(), WithSpoolMaxBytes(1), WithDrainInterval(0))
+	if err != nil {
+		t.Fatalf("unexpected error opening spool: %v", err)
+	}
+	defer s.Close()
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithSpool(s), WithRetryPolicy(RetryPolicy{Attempts: 1}))
+	defer client.Close()
+
+	// the request is still sent, just not spooled
+	if err := client.DeleteMetricData("metric"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	if err := client.DeleteMetricData("metric"); !errors.Is(err, ErrSpoolFull) || errors.Is(err, ErrSpooled) {
+		t.Errorf("expected an error that is not spooled, as the spool is full, but got `%v`", err)
+	}
+	if s.Len() != 0 {
+		t.Errorf("expected no spooled requests but got %d", s.Len())
+	}
+}
+
+func Test_Client_SpoolRequeue(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	// the oldest request always fails
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "stuck" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		sent = append(sent, id)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, WithSegmentBytes(1), WithDrainInterval(0))
+	if err != nil {
+		t.Fatalf("unexpected error opening spool: %v", err)
+	}
+	s.append(&request{uri: DeleteURI, metricID: "metric", body: []byte("{}"), id: "stuck"})
+	s.append(&request{uri: DeleteURI, metricID: "metric", body: []byte("{}"), id: "next"})
+	s.release(s.pending[0])
+	s.release(s.pending[1])
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithSpool(s), WithRetryPolicy(RetryPolicy{Attempts: 1}))
+	defer client.Close()
+
+	for i := 1; i <= spoolMaxFailures; i++ {
+		if err := client.DrainSpool(context.Background()); err == nil {
+			t.Fatalf("expected drain %d to fail", i)
+		}
+		if want := i == spoolMaxFailures; (len(sent) == 1) != want {
+			t.Fatalf("expected the next request sent only on drain %d, but after drain %d got %v", spoolMaxFailures, i, sent)
+		}
+	}
+
+	// the failing request is kept, and no longer holds on to the segments
+	// behind it
+	if s.Len() != 1 || s.pending[0].req.id != "stuck" {
+		t.Fatalf("expected only the failing request to be kept")
+	}
+	if s.segments[0] != s.pending[0].seg {
+		t.Errorf("expected the segments before the failing request's new record to be deleted")
+	}
+
+	s.Close()
+	s, err = OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("unexpected error reopening spool: %v", err)
+	}
+	defer s.Close()
+	if s.Len() != 1 || s.pending[0].req.id != "stuck" {
+		t.Errorf("expected only the failing request after reopening the spool")
+	}
+}
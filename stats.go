@@ -0,0 +1,199 @@
+package panobi
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upper bounds, in seconds, of the latency histogram buckets
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Snapshot of what a client has done since it was created. Endpoints are
+// named by the last part of their path, such as "timeseries".
+type Stats struct {
+	ItemsSent   uint64               // items in requests that were accepted
+	BatchesSent uint64               // requests that were accepted
+	BytesSent   uint64               // payload bytes before compression, counting every attempt
+	Retries     uint64               // attempts that were retried
+	RateLimited uint64               // 429 responses received
+	Failures    map[string]uint64    // requests that finally failed, by endpoint
+	Latency     map[string]Histogram // latency of attempts that got a response, by endpoint
+}
+
+// Distribution of observed values, in seconds.
+type Histogram struct {
+	Buckets []float64 // upper bound of each bucket
+	Counts  []uint64  // number of observations in each bucket, not cumulative
+	Count   uint64    // total number of observations, including those above the last bucket
+	Sum     float64   // sum of all observations
+}
+
+func newHistogram() Histogram {
+	return Histogram{
+		Buckets: latencyBuckets,
+		Counts:  make([]uint64, len(latencyBuckets)),
+	}
+}
+
+func (h *Histogram) observe(v float64) {
+	h.Count++
+	h.Sum += v
+
+	if i := sort.SearchFloat64s(h.Buckets, v); i < len(h.Buckets) {
+		h.Counts[i]++
+	}
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// Collects Stats by way of the client's hooks.
+type stats struct {
+	NopHooks
+
+	mu sync.Mutex
+	s  Stats
+}
+
+func newStats() *stats {
+	return &stats{
+		s: Stats{
+			Failures: make(map[string]uint64),
+			Latency:  make(map[string]Histogram),
+		},
+	}
+}
+
+func (st *stats) OnAttempt(_ context.Context, info AttemptInfo) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.BytesSent += uint64(info.PayloadBytes)
+}
+
+func (st *stats) OnResponse(_ context.Context, info AttemptInfo) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	endpoint := path.Base(info.Endpoint)
+	h, ok := st.s.Latency[endpoint]
+	if !ok {
+		h = newHistogram()
+	}
+	h.observe(info.Latency.Seconds())
+	st.s.Latency[endpoint] = h
+
+	if info.StatusCode == http.StatusTooManyRequests {
+		st.s.RateLimited++
+	}
+
+	if info.Err == nil {
+		st.s.BatchesSent++
+		st.s.ItemsSent += uint64(info.Items)
+	}
+}
+
+func (st *stats) OnRetry(context.Context, AttemptInfo, time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.Retries++
+}
+
+func (st *stats) OnError(_ context.Context, info RequestInfo, _ error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.Failures[path.Base(info.Endpoint)]++
+}
+
+func (st *stats) snapshot() Stats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s := st.s
+	s.Failures = make(map[string]uint64, len(st.s.Failures))
+	for k, v := range st.s.Failures {
+		s.Failures[k] = v
+	}
+	s.Latency = make(map[string]Histogram, len(st.s.Latency))
+	for k, v := range st.s.Latency {
+		s.Latency[k] = v.clone()
+	}
+
+	return s
+}
+
+// Returns a snapshot of what the client has done since it was created.
+func (client *Client) Stats() Stats {
+	return client.stats.snapshot()
+}
+
+// Publishes the client's stats as an expvar variable with the given name.
+// Like expvar.Publish, it panics if the name is already in use.
+func (client *Client) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return client.Stats()
+	}))
+}
+
+// Returns a handler that renders the client's stats in the Prometheus text
+// exposition format.
+func (client *Client) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writePrometheus(w, client.Stats())
+	})
+}
+
+func writePrometheus(w io.Writer, s Stats) {
+	counter := func(name, help string, v uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+
+	counter("panobi_items_sent_total", "Items in requests accepted by Panobi.", s.ItemsSent)
+	counter("panobi_batches_sent_total", "Requests accepted by Panobi.", s.BatchesSent)
+	counter("panobi_bytes_sent_total", "Payload bytes before compression, counting every attempt.", s.BytesSent)
+	counter("panobi_retries_total", "Attempts that were retried.", s.Retries)
+	counter("panobi_rate_limited_total", "429 responses received.", s.RateLimited)
+
+	fmt.Fprint(w, "# HELP panobi_failures_total Requests that finally failed.\n# TYPE panobi_failures_total counter\n")
+	for _, endpoint := range sortedKeys(s.Failures) {
+		fmt.Fprintf(w, "panobi_failures_total{endpoint=%q} %d\n", endpoint, s.Failures[endpoint])
+	}
+
+	fmt.Fprint(w, "# HELP panobi_request_duration_seconds Latency of attempts that got a response.\n# TYPE panobi_request_duration_seconds histogram\n")
+	for _, endpoint := range sortedKeys(s.Latency) {
+		h := s.Latency[endpoint]
+
+		var cumulative uint64
+		for i, le := range h.Buckets {
+			cumulative += h.Counts[i]
+			fmt.Fprintf(w, "panobi_request_duration_seconds_bucket{endpoint=%q,le=%q} %d\n",
+				endpoint, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "panobi_request_duration_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d\n", endpoint, h.Count)
+		fmt.Fprintf(w, "panobi_request_duration_seconds_sum{endpoint=%q} %s\n", endpoint, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+		fmt.Fprintf(w, "panobi_request_duration_seconds_count{endpoint=%q} %d\n", endpoint, h.Count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,123 @@
+package panobi
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+func Test_Stats(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	statuses := []int{429, 503, 200, 400}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// anything after the last status is rejected too
+		if calls < len(statuses)-1 {
+			w.WriteHeader(statuses[calls])
+		} else {
+			w.WriteHeader(statuses[len(statuses)-1])
+		}
+		calls++
+	}))
+	defer srv.Close()
+
+	client := CreateClient(ki, WithBaseURL(srv.URL), WithRetryPolicy(RetryPolicy{Attempts: 3, BackoffInitial: time.Millisecond}))
+	defer client.Close()
+
+	items := []MetricItem{{Date: civil.Date{Year: 2023, Month: 1, Day: 1}, Value: 1}, {Date: civil.Date{Year: 2023, Month: 1, Day: 2}, Value: 2}}
+	if err := client.SendMetricItems("metric", items); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := client.DeleteMetricData("metric"); err == nil {
+		t.Fatal("expected the delete to be rejected")
+	}
+
+	s := client.Stats()
+	if s.ItemsSent != 2 || s.BatchesSent != 1 {
+		t.Errorf("expected 2 items in 1 batch but got %d in %d", s.ItemsSent, s.BatchesSent)
+	}
+	if s.Retries != 2 || s.RateLimited != 1 {
+		t.Errorf("expected 2 retries and 1 rate-limited response but got %d and %d", s.Retries, s.RateLimited)
+	}
+	if s.BytesSent == 0 {
+		t.Error("expected bytes to be counted")
+	}
+	if s.Failures["delete"] != 1 || s.Failures["timeseries"] != 0 {
+		t.Errorf("expected a single delete failure but got %v", s.Failures)
+	}
+	if s.Latency["timeseries"].Count != 3 || s.Latency["delete"].Count != 1 {
+		t.Errorf("expected 3 timeseries and 1 delete latencies but got %d and %d", s.Latency["timeseries"].Count, s.Latency["delete"].Count)
+	}
+
+	// the snapshot is not changed by later requests
+	client.DeleteMetricData("metric")
+	if s.Failures["delete"] != 1 {
+		t.Errorf("expected the snapshot to be unchanged but got %v", s.Failures)
+	}
+}
+
+func Test_Stats_Prometheus(t *testing.T) {
+	st := newStats()
+	st.OnResponse(context.Background(), AttemptInfo{RequestInfo: RequestInfo{Endpoint: string(TimeseriesURI), Items: 3}, StatusCode: 200, Latency: 200 * time.Millisecond})
+	st.OnResponse(context.Background(), AttemptInfo{RequestInfo: RequestInfo{Endpoint: string(TimeseriesURI)}, StatusCode: 200, Latency: time.Minute})
+	st.OnError(context.Background(), RequestInfo{Endpoint: string(ChartDataURI)}, ErrRateLimited)
+
+	client := &Client{stats: st}
+	rec := httptest.NewRecorder()
+	client.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus text content type but got `%s`", ct)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"panobi_items_sent_total 3\n",
+		"panobi_batches_sent_total 2\n",
+		"# TYPE panobi_failures_total counter\n",
+		`panobi_failures_total{endpoint="chart-data"} 1` + "\n",
+		"# TYPE panobi_request_duration_seconds histogram\n",
+		`panobi_request_duration_seconds_bucket{endpoint="timeseries",le="0.1"} 0` + "\n",
+		`panobi_request_duration_seconds_bucket{endpoint="timeseries",le="0.25"} 1` + "\n",
+		`panobi_request_duration_seconds_bucket{endpoint="timeseries",le="30"} 1` + "\n",
+		`panobi_request_duration_seconds_bucket{endpoint="timeseries",le="+Inf"} 2` + "\n",
+		`panobi_request_duration_seconds_sum{endpoint="timeseries"} 60.2` + "\n",
+		`panobi_request_duration_seconds_count{endpoint="timeseries"} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected output to contain `%s` but got:\n%s", strings.TrimSpace(want), body)
+		}
+	}
+}
+
+var expvarRuns atomic.Int64
+
+func Test_Stats_Expvar(t *testing.T) {
+	st := newStats()
+	st.OnRetry(context.Background(), AttemptInfo{}, 0)
+
+	// names can only be published once per process, and the test may run
+	// more than once
+	name := fmt.Sprintf("panobi_test_stats_%d", expvarRuns.Add(1))
+
+	client := &Client{stats: st}
+	client.PublishExpvar(name)
+
+	var s Stats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &s); err != nil {
+		t.Fatalf("unexpected error decoding expvar: %v", err)
+	}
+	if s.Retries != 1 {
+		t.Errorf("expected 1 retry but got %d", s.Retries)
+	}
+}
@@ -7,3 +7,11 @@ func errorIs(want string, got error) bool {
 		return want == got.Error()
 	}
 }
+
+func testOptions(baseURL string, opts ...Option) options {
+	o := defaultOptions()
+	for _, opt := range append([]Option{WithBaseURL(baseURL)}, opts...) {
+		opt(&o)
+	}
+	return o
+}
@@ -2,13 +2,15 @@ package panobi
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,110 +18,372 @@ import (
 
 type apiURI string
 
+// namespace for request IDs derived from request contents
+var requestIDNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte(DefaultBaseURL+"/integrations/metrics-sdk"))
+
 const (
-	TimeseriesURI apiURI = "https://app.panobi.com/integrations/metrics-sdk/timeseries"
-	ChartDataURI  apiURI = "https://app.panobi.com/integrations/metrics-sdk/chart-data"
-	DeleteURI     apiURI = "https://app.panobi.com/integrations/metrics-sdk/delete"
+	// where requests are sent unless WithBaseURL is given
+	DefaultBaseURL string = "https://app.panobi.com"
 
-	attempts          int = 3
-	backoffInitial    int = 1
-	backoffMultiplier int = 2
+	TimeseriesURI apiURI = "/integrations/metrics-sdk/timeseries"
+	ChartDataURI  apiURI = "/integrations/metrics-sdk/chart-data"
+	DeleteURI     apiURI = "/integrations/metrics-sdk/delete"
 )
 
 type transport struct {
-	c  *http.Client
-	ki KeyInfo
+	c       *http.Client
+	keys    KeyProvider
+	baseURL string
+	timeout time.Duration
+	retry   RetryPolicy
+	stable  bool
+	scope   string
+	limiter *limiter
+	log     Logger
+	hooks   Hooks
+	tracer  func(context.Context) string
+	now     func() time.Time
+
+	skewMu sync.Mutex
+	skew   time.Duration // server time minus local time
+}
+
+// A logical request to the Panobi API, which may take several attempts.
+type request struct {
+	uri      apiURI
+	metricID string
+	items    int
+	body     []byte
+	id       string // X-Request-ID, the same for every attempt
+}
+
+type attemptResult struct {
+	body       []byte
+	status     int
+	latency    time.Duration
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+// Creates a transport that signs with the given key, unless a key provider
+// is given in the options.
+func createTransport(ki KeyInfo, o options) *transport {
+	keys := o.keys
+	if keys == nil {
+		keys = StaticKeyProvider(ki)
+	}
+
+	var c http.Client
+	if o.httpClient != nil {
+		c = *o.httpClient
+	}
+	if o.roundTripper != nil {
+		c.Transport = o.roundTripper
+	}
+
+	if o.retry.Attempts < 1 {
+		o.retry.Attempts = 1
+	}
+
+	t := &transport{
+		c:       &c,
+		keys:    keys,
+		baseURL: o.baseURL,
+		timeout: o.timeout,
+		retry:   o.retry,
+		stable:  o.stableRequestIDs,
+		scope:   o.requestIDScope,
+		limiter: newLimiter(o.rateLimit, o.burst),
+		log:     o.logger,
+		hooks:   multiHooks(o.hooks),
+		tracer:  o.traceparent,
+		now:     time.Now,
+	}
+	c.Transport = t.chain(c.Transport, o)
+
+	return t
 }
 
-func createTransport(ki KeyInfo) *transport {
-	return &transport{
-		c:  &http.Client{},
-		ki: ki,
+// Builds the round tripper every attempt goes through: the given
+// middleware, outermost first, then signing, the debug dump, compression
+// and finally the base round tripper. The signing round tripper hands a
+// copy of the request down, so the layers below it may modify it.
+func (t *transport) chain(base http.RoundTripper, o options) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	rt := base
+	if o.gzip {
+		rt = compressRequests(rt)
+	}
+	if o.debugDump {
+		rt = t.dumpRoundTripper(rt)
+	}
+	rt = &SigningRoundTripper{
+		Next:    rt,
+		Version: o.signatureVersion,
+		now:     t.serverNow,
 	}
+	for i := len(o.middleware) - 1; i >= 0; i-- {
+		rt = o.middleware[i](rt)
+	}
+
+	return rt
 }
 
-func (t *transport) post(uri apiURI, input []byte) ([]byte, error) {
-	si, err := CalculateSignature(input, t.ki, nil)
+// Sends the request, retrying as needed. Every attempt carries the same
+// request ID, so that the server can recognise a retry of a request that
+// actually succeeded, and is signed with the same key. Errors other than an
+// APIError are wrapped to include the request ID.
+func (t *transport) post(ctx context.Context, req *request) ([]byte, error) {
+	ki, err := t.key(ctx)
 	if err != nil {
 		return nil, err
 	}
+	// the provider may destroy its key when it rotates, so the request
+	// signs with a copy of its own
+	ki = ki.clone()
+	defer ki.Destroy()
+
+	if req.id == "" {
+		req.id = t.requestID(req, ki)
+	}
+
+	info := req.info()
+	ctx = t.hooks.OnRequestStart(ctx, info)
+
+	b, err := t.send(ctx, req, ki)
+	if err != nil {
+		t.hooks.OnError(ctx, info, err)
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			err = fmt.Errorf("request %s: %w", req.id, err)
+		}
+	}
+
+	return b, err
+}
+
+func (t *transport) send(ctx context.Context, req *request, ki KeyInfo) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(err)
+	}
+
+	if len(req.body) > maxInputBytes {
+		return nil, newLimitError(ErrPayloadTooLarge, "input", maxInputBytes, "bytes")
+	}
 
 	url := fmt.Sprintf(
-		"%s/%s/%s",
-		uri,
-		url.PathEscape(t.ki.WorkspaceID),
-		url.PathEscape(t.ki.ExternalID))
-	backoff := backoffInitial
-	i := 1
-
-	for {
-		b, err := func() ([]byte, error) {
-			req, err := http.NewRequest("POST", url, bytes.NewReader(input))
-			if err != nil {
-				return nil, err
-			}
+		"%s%s/%s/%s",
+		t.baseURL,
+		req.uri,
+		url.PathEscape(ki.WorkspaceID),
+		url.PathEscape(ki.ExternalID))
 
-			req.Header = t.getHeaders(si)
+	start := t.now()
+	var lastSigned time.Time
+	ctx = withLastSigned(ctx, &lastSigned)
 
-			resp, err := t.c.Do(req)
-			if err != nil {
-				return nil, err
-			}
+	for i := 1; ; i++ {
+		if err := t.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		ai := AttemptInfo{RequestInfo: req.info(), Attempt: i}
+		t.hooks.OnAttempt(ctx, ai)
+
+		wait := jitter(t.retry.backoff(i))
+		r := t.attempt(ctx, req, ki, url, wait)
+
+		ai.StatusCode, ai.Latency, ai.Err = r.status, r.latency, r.err
+		if r.status != 0 {
+			t.hooks.OnResponse(ctx, ai)
+		}
+
+		if r.err == nil {
+			return r.body, nil
+		}
 
-			defer func() {
-				if err := resp.Body.Close(); err != nil {
-					fmt.Fprintln(os.Stderr, "Error closing body:", err)
-				}
-			}()
-
-			body, err := io.ReadAll(resp.Body)
-			switch code := resp.StatusCode; {
-			case code >= 200 && code < 300:
-				return body, err
-			case code == 408 || code == 429:
-				if i == attempts {
-					return nil, fmt.Errorf("http error %d: %s", resp.StatusCode, body)
-				}
-				time.Sleep(getRetryAfter(resp, backoff))
-				backoff = backoff * backoffMultiplier
-				i++
-				return nil, nil
-			default:
-				return nil, fmt.Errorf("http error %d: %s", resp.StatusCode, body)
+		if !r.retryable ||
+			i >= t.retry.Attempts ||
+			t.retry.MaxElapsed > 0 && t.now().Sub(start)+r.retryAfter > t.retry.MaxElapsed {
+			var apiErr *APIError
+			if errors.As(r.err, &apiErr) {
+				apiErr.Retries = i - 1
 			}
-		}()
+			return nil, r.err
+		}
 
-		if err != nil {
+		t.hooks.OnRetry(ctx, ai, r.retryAfter)
+		t.log.Info("panobi: retrying request",
+			"endpoint", req.uri,
+			"request_id", req.id,
+			"attempt", i,
+			"wait", r.retryAfter,
+			"error", redact(r.err.Error(), ki))
+
+		if err := sleep(ctx, r.retryAfter); err != nil {
 			return nil, err
 		}
+	}
+}
+
+// Makes a single attempt at sending the request. Each attempt is signed
+// afresh by the round tripper chain, so that the timestamp stays current
+// across long waits. If the attempt may be retried, the result says how long
+// to wait, falling back to the given default when the server does not say.
+func (t *transport) attempt(ctx context.Context, r *request, ki KeyInfo, url string, wait time.Duration) attemptResult {
+	actx := ctx
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(withSigningKey(actx, ki), "POST", url, bytes.NewReader(r.body))
+	if err != nil {
+		return attemptResult{err: err}
+	}
+
+	req.Header = getHeaders(r.id)
+	if tp := t.tracer(ctx); tp != "" {
+		req.Header.Set("traceparent", tp)
+	}
+
+	sent, sentAt, signedAt := time.Now(), t.now(), t.serverNow()
+	resp, err := t.c.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return attemptResult{err: canceled(ctxErr)}
+		}
+		return attemptResult{err: err, retryable: isRetryableError(err), retryAfter: wait}
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			t.log.Warn("panobi: error closing response body", "error", err)
+		}
+	}()
 
-		if b != nil {
-			return b, nil
+	body, err := io.ReadAll(resp.Body)
+	latency := time.Since(sent)
+	t.observeDate(resp, sentAt, t.now())
+	t.log.Debug("panobi: response",
+		"endpoint", r.uri,
+		"request_id", r.id,
+		"status", resp.StatusCode,
+		"latency", latency)
+
+	if code := resp.StatusCode; code >= 200 && code < 300 {
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = canceled(ctxErr)
+			}
 		}
+		return attemptResult{body: body, status: code, latency: latency, err: err}
+	}
+
+	result := attemptResult{
+		status:     resp.StatusCode,
+		latency:    latency,
+		err:        newAPIError(resp, body, r.uri, r.id),
+		retryable:  isRetryableStatus(resp.StatusCode),
+		retryAfter: getRetryAfter(resp, wait),
+	}
+	// the skew has been corrected for by now, so a retry is signed with a
+	// timestamp the server accepts
+	if skew, ok := rejectedForSkew(resp, signedAt); ok {
+		result.err = &ClockSkewError{Skew: skew, Err: result.err.(*APIError)}
+		result.retryable = true
 	}
+	// when the server asks us to back off, every request made through this
+	// transport waits, not just this one
+	if resp.StatusCode == http.StatusTooManyRequests {
+		t.log.Info("panobi: rate limited, pausing all requests", "wait", result.retryAfter)
+		t.limiter.pause(result.retryAfter)
+	}
+
+	return result
+}
+
+// Returns the key to sign a request with.
+func (t *transport) key(ctx context.Context) (KeyInfo, error) {
+	ki, err := t.keys.Key(ctx)
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("signing key: %w", err)
+	}
+
+	return ki, nil
+}
+
+// Returns a random request ID, or one derived from the request itself if
+// stable request IDs are enabled. A delete says nothing but the metric ID,
+// so its ID would be the same every time the metric is deleted.
+func (t *transport) requestID(req *request, ki KeyInfo) string {
+	if !t.stable || req.uri == DeleteURI {
+		return uuid.NewString()
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s\n", req.uri, ki.WorkspaceID, ki.ExternalID, req.metricID, t.scope)
+	h.Write(req.body)
+
+	return uuid.NewSHA1(requestIDNamespace, h.Sum(nil)).String()
 }
 
-func (t *transport) getHeaders(si SignatureInfo) http.Header {
+func getHeaders(requestID string) http.Header {
 	headers := make(http.Header)
 
 	headers.Set("Content-Type", "application/json")
-	headers.Set("X-Panobi-Signature", si.S)
-	headers.Set("X-Panobi-Request-Timestamp", si.TS)
-	headers.Set("X-Request-ID", uuid.NewString())
+	headers.Set("X-Request-ID", requestID)
 
 	return headers
 }
 
-func getRetryAfter(resp *http.Response, defaultRetryAfter int) time.Duration {
-	headerVal := strings.TrimSpace(resp.Header.Get("Retry-After"))
-	if headerVal == "" {
-		return time.Duration(defaultRetryAfter) * time.Second
+// gzip writers are large, so they are reused between requests
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+func compress(input []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gzipTo(&buf, func(w io.Writer) error {
+		_, err := w.Write(input)
+		return err
+	})
+
+	return buf.Bytes(), err
+}
+
+// Compresses whatever write writes into dst.
+func gzipTo(dst io.Writer, write func(io.Writer) error) error {
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(dst)
+
+	if err := write(zw); err != nil {
+		return err
 	}
 
-	retryAfter, err := strconv.ParseInt(headerVal, 10, 64)
-	if err != nil {
-		return time.Duration(defaultRetryAfter) * time.Second
+	return zw.Close()
+}
+
+// Waits for the given duration, returning early if the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return canceled(ctx.Err())
+	case <-timer.C:
+		return nil
 	}
+}
 
-	return time.Duration(retryAfter) * time.Second
+func canceled(err error) error {
+	return fmt.Errorf("request canceled: %w", err)
 }
@@ -0,0 +1,279 @@
+package panobi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_post_Context(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName  string
+		ctx       func() (context.Context, context.CancelFunc)
+		wantCalls int32
+		wantErr   error
+	}{
+		{
+			testName: "canceled before sending",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantCalls: 0,
+			wantErr:   context.Canceled,
+		},
+		{
+			testName: "deadline during backoff",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			wantCalls: 1,
+			wantErr:   context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer srv.Close()
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			_, err := createTransport(ki, testOptions(srv.URL)).post(ctx, &request{uri: TimeseriesURI, body: []byte("{}")})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected err to wrap `%v` but got `%v`", tt.wantErr, err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected to return promptly but took %s", elapsed)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("expected %d call(s) but got %d", tt.wantCalls, got)
+			}
+		})
+	}
+}
+
+func Test_post_Retry(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName   string
+		statuses   []int
+		wantCalls  int
+		wantErr    bool
+		closeConns bool
+	}{
+		{
+			testName:  "retries 5xx",
+			statuses:  []int{502, 503, 200},
+			wantCalls: 3,
+		},
+		{
+			testName:  "gives up after attempts",
+			statuses:  []int{500, 500, 500, 500},
+			wantCalls: 3,
+			wantErr:   true,
+		},
+		{
+			testName:  "does not retry 400",
+			statuses:  []int{400, 200},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			testName:   "retries connection errors",
+			statuses:   []int{0, 200},
+			wantCalls:  2,
+			closeConns: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var mu sync.Mutex
+			var timestamps []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[len(timestamps)]
+				timestamps = append(timestamps, r.Header.Get("X-Panobi-Request-Timestamp"))
+				mu.Unlock()
+
+				if status == 0 {
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+					return
+				}
+				// without a Date header the fake clock below is not
+				// corrected for skew
+				w.Header()["Date"] = nil
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			tr := createTransport(ki, testOptions(srv.URL, WithRetryPolicy(RetryPolicy{
+				Attempts:          3,
+				BackoffInitial:    time.Millisecond,
+				BackoffMultiplier: 2,
+			})))
+			now := time.UnixMilli(1672552800000)
+			tr.now = func() time.Time {
+				now = now.Add(time.Second)
+				return now
+			}
+
+			_, err := tr.post(context.Background(), &request{uri: TimeseriesURI, body: []byte("{}")})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %t but got `%v`", tt.wantErr, err)
+			}
+			if len(timestamps) != tt.wantCalls {
+				t.Fatalf("expected %d call(s) but got %d", tt.wantCalls, len(timestamps))
+			}
+			for i := 1; i < len(timestamps); i++ {
+				if timestamps[i] == timestamps[i-1] {
+					t.Errorf("expected each attempt to be signed afresh but got timestamp %s twice", timestamps[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_post_Gzip(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	input := []byte(`{"metricID":"metric","items":[]}`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("expected gzip content encoding but got `%s`", got)
+		}
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatalf("unexpected error reading body: %v", err)
+		}
+		body, _ := io.ReadAll(zr)
+		if !bytes.Equal(body, input) {
+			t.Errorf("expected body `%s` but got `%s`", input, body)
+		}
+
+		ms, _ := strconv.ParseInt(r.Header.Get("X-Panobi-Request-Timestamp"), 10, 64)
+		ts := time.UnixMilli(ms)
+		si, _ := CalculateSignature(body, ki, &ts)
+		if got := r.Header.Get("X-Panobi-Signature"); got != si.S {
+			t.Errorf("expected signature over uncompressed body `%s` but got `%s`", si.S, got)
+		}
+	}))
+	defer srv.Close()
+
+	tr := createTransport(ki, testOptions(srv.URL, WithGzip()))
+	if _, err := tr.post(context.Background(), &request{uri: ChartDataURI, body: input}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_post_RequestID(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	tests := []struct {
+		testName   string
+		uri        apiURI
+		opts       []Option
+		secondOpts []Option // for the second send of the same payload, if not opts
+		wantStable bool
+	}{
+		{
+			testName:   "random",
+			uri:        TimeseriesURI,
+			wantStable: false,
+		},
+		{
+			testName:   "stable",
+			uri:        TimeseriesURI,
+			opts:       []Option{WithStableRequestIDs()},
+			wantStable: true,
+		},
+		{
+			testName:   "stable within a scope",
+			uri:        TimeseriesURI,
+			opts:       []Option{WithStableRequestIDs(), WithRequestIDScope("2023-01-01")},
+			wantStable: true,
+		},
+		{
+			testName:   "stable in another scope",
+			uri:        TimeseriesURI,
+			opts:       []Option{WithStableRequestIDs(), WithRequestIDScope("2023-01-01")},
+			secondOpts: []Option{WithStableRequestIDs(), WithRequestIDScope("2023-01-02")},
+			wantStable: false,
+		},
+		{
+			testName:   "stable delete",
+			uri:        DeleteURI,
+			opts:       []Option{WithStableRequestIDs()},
+			wantStable: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var mu sync.Mutex
+			var ids []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				ids = append(ids, r.Header.Get("X-Request-ID"))
+				n := len(ids)
+				mu.Unlock()
+
+				// fail every first attempt
+				if n%2 == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer srv.Close()
+
+			send := func(body string, opts []Option) string {
+				opts = append([]Option{WithRetryPolicy(RetryPolicy{Attempts: 2, BackoffInitial: time.Millisecond})}, opts...)
+				req := &request{uri: tt.uri, metricID: "metric", body: []byte(body)}
+				if _, err := createTransport(ki, testOptions(srv.URL, opts...)).post(context.Background(), req); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return req.id
+			}
+
+			secondOpts := tt.opts
+			if tt.secondOpts != nil {
+				secondOpts = tt.secondOpts
+			}
+			first := send(`{"a":1}`, tt.opts)
+			second := send(`{"a":1}`, secondOpts)
+			other := send(`{"a":2}`, tt.opts)
+
+			if ids[0] != first || ids[1] != first {
+				t.Errorf("expected request ID `%s` on every attempt but got %v", first, ids[:2])
+			}
+			if (first == second) != tt.wantStable {
+				t.Errorf("expected stable request IDs %t but got `%s` and `%s`", tt.wantStable, first, second)
+			}
+			if first == other {
+				t.Errorf("expected different payloads to get different request IDs")
+			}
+		})
+	}
+}
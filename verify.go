@@ -0,0 +1,248 @@
+package panobi
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// no key matches the workspace and external IDs in the request path
+	ErrUnknownKey = errors.New("unknown key")
+	// the request was already seen
+	ErrReplayed = errors.New("replayed request")
+	// the request body cannot be read or decompressed
+	ErrMalformedBody = errors.New("malformed body")
+)
+
+// Remembers requests that have been seen, so that replays can be rejected.
+// Implementations must be safe for concurrent use.
+type ReplayStore interface {
+	// Records the key, reporting whether it was already recorded and has
+	// not yet expired. Keys never need to be remembered past their expiry,
+	// since the request would be rejected as stale by then anyway.
+	Seen(key string, expires time.Time) (bool, error)
+}
+
+// A ReplayStore that keeps keys in memory, for a single process.
+type MemoryReplayStore struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	prune int
+	now   func() time.Time
+}
+
+// Creates an empty in-memory replay store.
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{
+		seen: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+func (s *MemoryReplayStore) Seen(key string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if exp, ok := s.seen[key]; ok && now.Before(exp) {
+		return true, nil
+	}
+	s.seen[key] = expires
+
+	// expired keys are dropped whenever the map has doubled in size, which
+	// keeps the cost of pruning constant per key
+	if len(s.seen) > s.prune {
+		for k, exp := range s.seen {
+			if !now.Before(exp) {
+				delete(s.seen, k)
+			}
+		}
+		s.prune = 2 * len(s.seen)
+	}
+
+	return false, nil
+}
+
+// Configures VerifyRequests.
+type VerifyOption func(*verifyOptions)
+
+type verifyOptions struct {
+	tolerance time.Duration
+	replays   ReplayStore
+	version   SignatureVersion
+	now       func() time.Time
+}
+
+// Accepts request timestamps up to the given duration from the current
+// time. The default is DefaultSignatureTolerance.
+func WithSignatureTolerance(d time.Duration) VerifyOption {
+	return func(o *verifyOptions) {
+		o.tolerance = d
+	}
+}
+
+// Only accepts signatures of the given version. By default both v0 and v1
+// signatures are accepted.
+func WithRequiredSignatureVersion(v SignatureVersion) VerifyOption {
+	return func(o *verifyOptions) {
+		o.version = v
+	}
+}
+
+// Records requests in the given store to reject replays. By default each
+// handler keeps its own MemoryReplayStore; nil turns replay checks off.
+//
+// A v0 signature only covers the timestamp and body, so it cannot tell a
+// replay from another request with the same body signed within the same
+// millisecond, and rejects the later one. The client never signs two
+// attempts at one request alike, but if separate requests may carry the
+// same body, require v1 signatures, which also cover the request ID.
+func WithReplayStore(s ReplayStore) VerifyOption {
+	return func(o *verifyOptions) {
+		o.replays = s
+	}
+}
+
+// Wraps a handler so that it only receives requests signed with one of the
+// given keys, the same way the Panobi API authenticates them. The key is
+// chosen by the workspace and external IDs at the end of the request path;
+// when several keys share those IDs, as during key rotation, any of them
+// may have signed the request. Gzip-compressed bodies are decompressed. The
+// next handler gets the verified, uncompressed body. Rejected requests get
+// a ResponseError.
+func VerifyRequests(keys []KeyInfo, next http.Handler, opts ...VerifyOption) http.Handler {
+	o := verifyOptions{
+		tolerance: DefaultSignatureTolerance,
+		replays:   NewMemoryReplayStore(),
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tolerance <= 0 {
+		o.tolerance = DefaultSignatureTolerance
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, status, err := verifyRequest(r, keys, o)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+
+		r.Header.Del("Content-Encoding")
+		setBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the uncompressed body of the request if it is authentic, or the
+// status code to reject it with.
+func verifyRequest(r *http.Request, keys []KeyInfo, o verifyOptions) ([]byte, int, error) {
+	body, err := readVerifiedBody(r)
+	if errors.Is(err, ErrPayloadTooLarge) {
+		return nil, http.StatusRequestEntityTooLarge, err
+	} else if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	dir, externalID := path.Split(path.Clean(r.URL.Path))
+	workspaceID := path.Base(dir)
+
+	signature := r.Header.Get("X-Panobi-Signature")
+	timestamp := r.Header.Get("X-Panobi-Request-Timestamp")
+
+	if o.version != "" && !strings.HasPrefix(signature, string(o.version)+"=") {
+		return nil, http.StatusUnauthorized, fmt.Errorf("%w: %s is required", ErrUnsupportedSignature, o.version)
+	}
+
+	err = ErrUnknownKey
+	for _, ki := range keys {
+		if ki.WorkspaceID != workspaceID || ki.ExternalID != externalID {
+			continue
+		}
+		if err = verifyRequestSignature(r, body, ki, o.tolerance, o.now()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	if o.replays != nil {
+		// a v1 signature covers the request ID, so it cannot be changed to
+		// pass a replay off as a new request. A v0 signature does not, so
+		// it is keyed on the signature alone.
+		key := signature
+		if strings.HasPrefix(signature, string(SignatureV1)+"=") {
+			key = r.Header.Get("X-Request-ID") + " " + signature
+		}
+
+		ms, _ := strconv.ParseInt(timestamp, 10, 64)
+		seen, err := o.replays.Seen(key, time.UnixMilli(ms).Add(o.tolerance))
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if seen {
+			return nil, http.StatusUnauthorized, ErrReplayed
+		}
+	}
+
+	return body, http.StatusOK, nil
+}
+
+// Reads the body, decompressing it if needed, up to the most the Panobi API
+// accepts.
+func readVerifiedBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	defer r.Body.Close()
+
+	var src io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedBody, err)
+		}
+		defer zr.Close()
+		src = zr
+	default:
+		return nil, fmt.Errorf("%w: unsupported content encoding %q", ErrMalformedBody, r.Header.Get("Content-Encoding"))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(src, int64(maxInputBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedBody, err)
+	}
+	if len(b) > maxInputBytes {
+		return nil, newLimitError(ErrPayloadTooLarge, "input", maxInputBytes, "bytes")
+	}
+
+	return b, nil
+}
+
+// Writes an error in the same format as the Panobi API.
+func writeError(w http.ResponseWriter, status int, message string) {
+	var re struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	re.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&re)
+}
@@ -0,0 +1,297 @@
+package panobi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_VerifyRequests(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+	rotated, _ := ParseKey("1234567890123456789012-1234567890123456789012-456")
+	other, _ := ParseKey("9999999999999999999999-1234567890123456789012-123")
+	path := string(TimeseriesURI) + "/1234567890123456789012/1234567890123456789012"
+
+	tests := []struct {
+		testName   string
+		signWith   KeyInfo
+		keys       []KeyInfo
+		body       string
+		age        time.Duration
+		wantStatus int
+		wantErr    string
+	}{
+		{
+			testName:   "success",
+			signWith:   ki,
+			keys:       []KeyInfo{ki},
+			body:       `{"metricID":"metric"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			testName:   "rotated key",
+			signWith:   ki,
+			keys:       []KeyInfo{rotated, ki},
+			body:       `{"metricID":"metric"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			testName:   "wrong key",
+			signWith:   rotated,
+			keys:       []KeyInfo{ki},
+			body:       `{"metricID":"metric"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "signature mismatch",
+		},
+		{
+			testName:   "no key for path",
+			signWith:   ki,
+			keys:       []KeyInfo{other},
+			body:       `{"metricID":"metric"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "unknown key",
+		},
+		{
+			testName:   "stale",
+			signWith:   ki,
+			keys:       []KeyInfo{ki},
+			body:       `{"metricID":"metric"}`,
+			age:        time.Hour,
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "stale timestamp",
+		},
+		{
+			testName:   "too large",
+			signWith:   ki,
+			keys:       []KeyInfo{ki},
+			body:       strings.Repeat("x", maxInputBytes+1),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantErr:    "input cannot be larger than 1048576 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var got []byte
+			h := VerifyRequests(tt.keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = io.ReadAll(r.Body)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(tt.body))
+			req.Header.Set("X-Request-ID", "id")
+			if len(tt.body) <= maxInputBytes {
+				signRequest(req, tt.signWith, SignatureV0, time.Now().Add(-tt.age))
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d but got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantErr != "" {
+				if msg := parseErrorMessage(rec.Body.Bytes()); !strings.HasPrefix(msg, tt.wantErr) {
+					t.Errorf("expected error `%s` but got `%s`", tt.wantErr, msg)
+				}
+			} else if string(got) != tt.body {
+				t.Errorf("expected the next handler to get `%s` but got `%s`", tt.body, got)
+			}
+		})
+	}
+}
+
+func Test_VerifyRequests_Client(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	var bodies []string
+	var signed []*http.Request
+	calls := 0
+	srv := httptest.NewServer(VerifyRequests([]KeyInfo{ki}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+
+		if calls++; calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})))
+	defer srv.Close()
+
+	keep := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			signed = append(signed, r.Clone(r.Context()))
+			return next.RoundTrip(r)
+		})
+	}
+
+	// a compressed request that is retried with the same request ID is not
+	// mistaken for a replay
+	client := CreateClient(ki,
+		WithBaseURL(srv.URL),
+		WithGzip(),
+		WithRoundTripper(keep(http.DefaultTransport)),
+		WithRetryPolicy(RetryPolicy{Attempts: 2, BackoffInitial: time.Millisecond}))
+	defer client.Close()
+
+	if err := client.DeleteMetricData("metric"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bodies) != 2 || bodies[1] != `{"metricID":"metric"}` {
+		t.Errorf("expected 2 uncompressed bodies but got %v", bodies)
+	}
+
+	// a copy of an earlier attempt is a replay, even under another request
+	// ID, which a v0 signature does not cover
+	for i, requestID := range []string{"", "other"} {
+		replay := signed[i].Clone(context.Background())
+		replay.Body, _ = replay.GetBody()
+		if requestID != "" {
+			replay.Header.Set("X-Request-ID", requestID)
+		}
+
+		resp, err := http.DefaultTransport.RoundTrip(replay)
+		if err != nil {
+			t.Fatalf("unexpected error replaying: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected the replay of attempt %d to be rejected but got status %d", i+1, resp.StatusCode)
+		}
+	}
+}
+
+func Test_VerifyRequests_Retry(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	for _, version := range []SignatureVersion{SignatureV0, SignatureV1} {
+		t.Run(string(version), func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(VerifyRequests([]KeyInfo{ki}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls++; calls == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			})))
+			defer srv.Close()
+
+			tr := createTransport(ki, testOptions(srv.URL,
+				WithSignatureVersion(version),
+				WithRetryPolicy(RetryPolicy{Attempts: 2, BackoffInitial: time.Nanosecond})))
+
+			// both attempts would otherwise be signed with the same
+			// timestamp, and so be identical
+			now := time.Now()
+			tr.now = func() time.Time { return now }
+
+			if _, err := tr.post(context.Background(), &request{uri: TimeseriesURI, body: []byte("{}")}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if calls != 2 {
+				t.Errorf("expected 2 calls but got %d", calls)
+			}
+		})
+	}
+}
+
+func Test_VerifyRequests_SignatureVersion(t *testing.T) {
+	ki, _ := ParseKey("1234567890123456789012-1234567890123456789012-123")
+
+	srv := httptest.NewServer(VerifyRequests([]KeyInfo{ki}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithRequiredSignatureVersion(SignatureV1)))
+	defer srv.Close()
+
+	for _, version := range []SignatureVersion{SignatureV0, SignatureV1} {
+		client := CreateClient(ki, WithBaseURL(srv.URL), WithGzip(), WithSignatureVersion(version), WithRetryPolicy(RetryPolicy{Attempts: 1}))
+		defer client.Close()
+
+		err := client.DeleteMetricData("metric")
+		if version == SignatureV0 && !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("expected a v0 signature to be rejected but got `%v`", err)
+		}
+		if version == SignatureV1 && err != nil {
+			t.Errorf("unexpected error with a v1 signature: %v", err)
+		}
+	}
+}
+
+func Test_MemoryReplayStore(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryReplayStore()
+	s.now = func() time.Time { return now }
+
+	if seen, _ := s.Seen("a", now.Add(time.Minute)); seen {
+		t.Error("expected a new key not to have been seen")
+	}
+	if seen, _ := s.Seen("a", now.Add(time.Minute)); !seen {
+		t.Error("expected a repeated key to have been seen")
+	}
+
+	now = now.Add(time.Minute)
+	if seen, _ := s.Seen("a", now.Add(time.Minute)); seen {
+		t.Error("expected an expired key not to have been seen")
+	}
+
+	for _, k := range []string{"b", "c", "d"} {
+		s.Seen(k, now)
+	}
+	if len(s.seen) > 2 {
+		t.Errorf("expected expired keys to be pruned but got %d", len(s.seen))
+	}
+}
+
+func Test_readVerifiedBody(t *testing.T) {
+	tests := []struct {
+		testName string
+		encoding string
+		body     []byte
+		wantErr  error
+	}{
+		{
+			testName: "gzip",
+			encoding: "gzip",
+			body:     mustCompress(`{}`),
+		},
+		{
+			testName: "not gzip",
+			encoding: "gzip",
+			body:     []byte(`{}`),
+			wantErr:  ErrMalformedBody,
+		},
+		{
+			testName: "unsupported encoding",
+			encoding: "br",
+			body:     []byte(`{}`),
+			wantErr:  ErrMalformedBody,
+		},
+		{
+			testName: "too large once decompressed",
+			encoding: "gzip",
+			body:     mustCompress(strings.Repeat(" ", maxInputBytes+1)),
+			wantErr:  ErrPayloadTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", tt.encoding)
+
+			if _, err := readVerifiedBody(req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected err to match `%v` but got `%v`", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func mustCompress(s string) []byte {
+	b, err := compress([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}